@@ -15,23 +15,34 @@
 package alloydbconn
 
 import (
+	"bufio"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
 	_ "embed"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	alloydbadmin "cloud.google.com/go/alloydb/apiv1beta"
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
 	"cloud.google.com/go/alloydb/connectors/apiv1beta/connectorspb"
+	"cloud.google.com/go/alloydbconn/debug"
 	"cloud.google.com/go/alloydbconn/errtype"
 	"cloud.google.com/go/alloydbconn/internal/alloydb"
 	"cloud.google.com/go/alloydbconn/internal/trace"
@@ -39,6 +50,8 @@ import (
 	"golang.org/x/net/proxy"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/protobuf/proto"
 )
@@ -52,9 +65,33 @@ const (
 	// ioTimeout is the maximum amount of time to wait before aborting a
 	// metadata exhange
 	ioTimeout = 30 * time.Second
+	// dialFailureThreshold is the number of failed dials to an instance
+	// within dialFailureWindow that force a refresh of its connection info,
+	// e.g., because maintenance changed the instance's IP address. At most
+	// one refresh is forced per dialFailureWindow.
+	dialFailureThreshold = 2
+	dialFailureWindow    = 30 * time.Second
 )
 
 var (
+	// ErrMaxConnections is wrapped by the DialError returned from Dial when
+	// the instance has reached the limit configured with
+	// WithMaxConnectionsPerInstance.
+	ErrMaxConnections = errors.New("maximum number of connections reached")
+
+	// ErrDialerClosing is wrapped by the DialError returned from Dial once
+	// DrainAndClose has been called.
+	ErrDialerClosing = errors.New("dialer is closing")
+
+	// ErrConnectionInfoUnavailable is wrapped by the DialError returned from
+	// Dial with WithFailFast when the instance has no valid cached connection
+	// info.
+	ErrConnectionInfoUnavailable = errors.New("no valid connection info is cached")
+
+	// ErrRefreshBudgetExhausted is wrapped by the RefreshError of a refresh
+	// that exceeded the budget configured with WithRefreshBudget.
+	ErrRefreshBudgetExhausted = alloydb.ErrRefreshBudgetExhausted
+
 	// versionString indicates the version of this library.
 	//go:embed version.txt
 	versionString string
@@ -73,15 +110,201 @@ func getDefaultKeys() (*rsa.PrivateKey, error) {
 	return defaultKey, defaultKeyErr
 }
 
+// loadOrGenerateKey loads a PEM encoded RSA private key from path. If the file
+// doesn't exist or doesn't contain a valid key of at least bits bits, a new
+// key is generated and written to path. Failing to write the key is not an
+// error: the generated key is used for the lifetime of the Dialer.
+func loadOrGenerateKey(path string, bits int, l debug.Logger) (*rsa.PrivateKey, error) {
+	key, err := loadKey(path, bits)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		l.Debugf("Failed to load cached RSA key from %v, generating a new key, err = %v", path, err)
+	}
+	key, err = rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKey(path, key); err != nil {
+		l.Debugf("Failed to cache RSA key in %v, err = %v", path, err)
+	}
+	return key, nil
+}
+
+func loadKey(path string, bits int) (*rsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, errors.New("file does not contain a PEM encoded RSA private key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if key.N.BitLen() < bits {
+		return nil, fmt.Errorf("key size is %d bits, want at least %d", key.N.BitLen(), bits)
+	}
+	return key, nil
+}
+
+// writeKey writes key to path, replacing any existing file atomically so a
+// concurrent reader never sees a partially written key.
+func writeKey(path string, key *rsa.PrivateKey) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = pem.Encode(f, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// nullLogger is the default debug.Logger and discards all log lines.
+type nullLogger struct{}
+
+func (nullLogger) Debugf(string, ...interface{}) {}
+
+// refreshingTokenSource is an oauth2.TokenSource whose underlying token source
+// can be replaced after an authentication failure, e.g., when federated
+// credentials have rotated, or with UpdateCredentials.
+type refreshingTokenSource struct {
+	refresh func(context.Context) (oauth2.TokenSource, error)
+	logger  debug.Logger
+
+	mu sync.Mutex
+	ts oauth2.TokenSource
+}
+
+// Token returns a token from the current token source.
+func (r *refreshingTokenSource) Token() (*oauth2.Token, error) {
+	r.mu.Lock()
+	ts := r.ts
+	r.mu.Unlock()
+	return ts.Token()
+}
+
+// reset replaces the current token source with one from the refresh func. If
+// the refresh func fails, the current token source is kept.
+func (r *refreshingTokenSource) reset() {
+	ts, err := r.refresh(context.Background())
+	if err != nil {
+		r.logger.Debugf("Failed to refresh token source, err = %v", err)
+		return
+	}
+	r.logger.Debugf("Token source refreshed after authentication failure")
+	r.set(ts)
+}
+
+// set replaces the current token source with ts.
+func (r *refreshingTokenSource) set(ts oauth2.TokenSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ts = oauth2.ReuseTokenSource(nil, ts)
+}
+
+// failoverTokenSource is an oauth2.TokenSource that returns a token from
+// fallback whenever primary fails to provide one.
+type failoverTokenSource struct {
+	primary  oauth2.TokenSource
+	fallback oauth2.TokenSource
+}
+
+// Token returns a token from the primary token source or, if that fails, from
+// the fallback token source.
+func (f failoverTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := f.primary.Token()
+	if err == nil {
+		return tok, nil
+	}
+	tok, ferr := f.fallback.Token()
+	if ferr != nil {
+		return nil, fmt.Errorf("primary token source failed: %w; fallback token source failed: %w", err, ferr)
+	}
+	return tok, nil
+}
+
+// refreshFunc adapts r to retrieve the connection info of each instance.
+func refreshFunc(r Refresher) alloydb.RefreshFunc {
+	return func(ctx context.Context, inst alloydb.InstanceURI, k *rsa.PrivateKey) (alloydb.RefreshData, error) {
+		res, err := r.Refresh(ctx, inst, k)
+		if err != nil {
+			return alloydb.RefreshData{}, err
+		}
+		addrs := make(map[string]string, len(res.IPAddrs))
+		for t, addr := range res.IPAddrs {
+			addrs[string(t)] = addr
+		}
+		return alloydb.RefreshData{
+			IPAddrs:    addrs,
+			ClientCert: res.ClientCert,
+			RootCAs:    res.RootCAs,
+			Expiry:     res.Expiry,
+		}, nil
+	}
+}
+
+// isAuthError reports whether err was caused by invalid or unavailable
+// credentials.
+func isAuthError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+		return true
+	}
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr)
+}
+
 type connectionInfoCache interface {
 	OpenConns() *uint64
-	ConnectInfo(context.Context) (string, *tls.Config, error)
+	MaxOpenConns() *uint64
+	ConnectInfo(context.Context, string) (string, *tls.Config, error)
+	ConnectInfoWithExpiry(context.Context, string) (string, *tls.Config, time.Time, error)
+	EngineVersion(context.Context) (string, error)
+	CertificateInfo(context.Context) (alloydb.CertificateInfo, error)
 	ForceRefresh()
+	WaitForRefresh(context.Context) error
+	IsValid() bool
+	CurrentRefreshAge() time.Duration
+	Health() alloydb.Health
 	io.Closer
 }
 
+// A Connector creates connections to AlloyDB instances. *Dialer implements
+// Connector. Code that depends on Connector rather than *Dialer can be unit
+// tested with a fake that returns, e.g., one end of a net.Pipe.
+type Connector interface {
+	// Dial returns a connection to the specified AlloyDB instance. See
+	// Dialer.Dial.
+	Dial(ctx context.Context, instance string, opts ...DialOption) (net.Conn, error)
+	// Close closes the Connector. See Dialer.Close.
+	Close() error
+}
+
+var _ Connector = (*Dialer)(nil)
+
 // A Dialer is used to create connections to AlloyDB instance.
 //
+// Methods that take an instance argument expect the instance's URI in the
+// format
+// projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>
+// or in the short format <PROJECT>:<REGION>:<CLUSTER>:<INSTANCE> (see
+// ParseInstanceURI). Methods that only act on an instance the Dialer already
+// caches, such as ForceRefresh, return an errtype.ConfigError if the instance
+// has not been dialed (or warmed up) before.
+//
 // Use NewDialer to initialize a Dialer.
 type Dialer struct {
 	lock sync.RWMutex
@@ -89,8 +312,21 @@ type Dialer struct {
 	instances      map[alloydb.InstanceURI]connectionInfoCache
 	key            *rsa.PrivateKey
 	refreshTimeout time.Duration
+	// dialTimeout, if set, limits connecting to an instance and completing
+	// the TLS handshake.
+	dialTimeout time.Duration
+	// refreshOpts configure the refresh behavior of each instance.
+	refreshOpts []alloydb.Option
 
 	client *alloydbadmin.AlloyDBAdminClient
+	// newClient, if set, creates client on first use (see
+	// WithLazyAdminClient). clientMu guards client and the retry state when
+	// newClient is set.
+	newClient        func() (*alloydbadmin.AlloyDBAdminClient, error)
+	clientMu         sync.Mutex
+	clientErr        error
+	clientBackoff    time.Duration
+	nextClientCreate time.Time
 
 	// defaultDialCfg holds the constructor level DialOptions, so that it can
 	// be copied and mutated by the Dial function.
@@ -101,26 +337,146 @@ type Dialer struct {
 	dialerID string
 
 	// dialFunc is the function used to connect to the address on the named
-	// network. By default it is golang.org/x/net/proxy#Dial.
+	// network. If nil, a net.Dialer configured with the dial's TCP keep-alive
+	// is used, honoring any proxy configured in the environment.
 	dialFunc func(cxt context.Context, network, addr string) (net.Conn, error)
+	// infoDialFunc, if set, is used in place of dialFunc and receives
+	// details of the instance being dialed.
+	infoDialFunc func(ctx context.Context, info DialInfo) (net.Conn, error)
+	// httpProxy, if set, is the HTTP proxy that connections are tunneled
+	// through in place of dialFunc.
+	httpProxy *url.URL
+
+	// onConnect and onDisconnect, if set, are called when a connection is
+	// opened and closed.
+	onConnect    func(InstanceURI)
+	onDisconnect func(InstanceURI, time.Duration)
+
+	// lazyRefresh configures the dialer to refresh connection info only
+	// when a connection is requested, rather than in the background.
+	lazyRefresh bool
+
+	// staticAddr and staticTLS, if set, are the connection info of every
+	// instance in place of the AlloyDB Admin API's.
+	staticAddr string
+	staticTLS  *tls.Config
+
+	// ipVersion is the IP version the address Dial connects to must be of.
+	ipVersion IPVersion
+
+	// insecureSkipVerify disables verification of the server side proxy's
+	// certificate.
+	insecureSkipVerify bool
+
+	// closeAtCertExpiry closes each connection once the client certificate
+	// it was opened with expires.
+	closeAtCertExpiry bool
+
+	// refreshInterval and refreshBurst are the effective rate limit on
+	// refreshes of each instance. Both are zero if refreshes are not rate
+	// limited.
+	refreshInterval time.Duration
+	refreshBurst    int
+
+	// maxConns is the maximum number of open connections per instance. Zero
+	// means there is no limit.
+	maxConns uint64
 
-	useIAMAuthN    bool
-	iamTokenSource oauth2.TokenSource
+	// maxInstances is the maximum number of cached instances. Zero means
+	// there is no limit.
+	maxInstances int
+	// idleTimeout is how long a cached instance may go without being dialed
+	// before it is closed. Zero means instances are never closed for being
+	// idle.
+	idleTimeout time.Duration
+	// lastUsed records when each cached instance was last dialed. It is only
+	// maintained when maxInstances or idleTimeout is set and is guarded by
+	// lock.
+	lastUsed map[alloydb.InstanceURI]instanceUse
+	useSeq   uint64
+	// now returns the current time. It is only replaced in tests.
+	now func() time.Time
+	// dialFailures tracks failed dials to decide when to force a refresh.
+	dialFailures dialFailures
+	// stopSweeper stops the goroutine that closes idle instances, and
+	// sweeperDone is closed once it has stopped. Both are nil if idleTimeout
+	// is not set.
+	stopSweeper context.CancelFunc
+	sweeperDone chan struct{}
+
+	// useIAMAuthN enables automatic IAM database authentication. When
+	// enabled, the OAuth2 token from iamTokenSource is used in place of a
+	// database password.
+	useIAMAuthN bool
+	// iamTokenSource provides the OAuth2 token sent during the metadata
+	// exchange. It is kept separate from the Admin API client's credentials
+	// and caches tokens until shortly before they expire.
+	iamTokenSource *refreshingTokenSource
+
+	// baseAdminOpts are the options of the Admin API client without its
+	// credentials, used by UpdateCredentials to recreate the client.
+	// adminUsesIAMTS reports whether the client's credentials are
+	// iamTokenSource, in which case replacing it suffices. Both are guarded
+	// by credMu. sharedClient reports whether the client was provided with
+	// WithAdminClient. adminTransport is the protocol of the client.
+	credMu         sync.Mutex
+	baseAdminOpts  []option.ClientOption
+	adminTransport Transport
+	adminUsesIAMTS bool
+	sharedClient   bool
 	userAgent      string
 
+	logger debug.Logger
+
 	buffer *buffer
+
+	// closing is set to 1 once DrainAndClose is called, after which Dial
+	// fails.
+	closing int32
+	// dialing is the number of calls to Dial in progress.
+	dialing int64
+
+	// dials and dialErrs count the calls to Dial and the failed ones.
+	dials    uint64
+	dialErrs uint64
+	// refreshes and refreshErrs count the refreshes of every instance and
+	// the failed ones.
+	refreshes   uint64
+	refreshErrs uint64
+
+	// events fans out refresh events to the subscribers.
+	events *refreshEvents
+
+	// refreshSpacing is the time between the refreshes forced by
+	// ForceRefreshAll, so that they stay within the refresh budget.
+	refreshSpacing time.Duration
+	// forcedRefreshes holds the timers of the refreshes that ForceRefreshAll
+	// has yet to force, keyed by instance. It is guarded by lock and nil once
+	// the Dialer is closed.
+	forcedRefreshes map[alloydb.InstanceURI]*time.Timer
 }
 
-// NewDialer creates a new Dialer.
+// newAdminClient creates an AlloyDB Admin API client that uses the provided
+// transport.
+func newAdminClient(ctx context.Context, t Transport, opts ...option.ClientOption) (*alloydbadmin.AlloyDBAdminClient, error) {
+	if t == GRPCTransport {
+		return alloydbadmin.NewAlloyDBAdminClient(ctx, opts...)
+	}
+	return alloydbadmin.NewAlloyDBAdminRESTClient(ctx, opts...)
+}
+
+// NewDialer creates a new Dialer. The provided context bounds the lifetime of
+// the Dialer's background refreshes: once it is done, no further refreshes
+// are scheduled, as when the Dialer is closed.
 //
 // Initial calls to NewDialer make take longer than normal because generation of an
-// RSA keypair is performed. Calls with a WithRSAKeyPair DialOption or after a default
+// RSA keypair is performed. Calls with a WithRSAKey Option or after a default
 // RSA keypair is generated will be faster.
 func NewDialer(ctx context.Context, opts ...Option) (*Dialer, error) {
 	cfg := &dialerConfig{
 		refreshTimeout: alloydb.RefreshTimeout,
-		dialFunc:       proxy.Dial,
 		userAgents:     []string{userAgent},
+		logger:         nullLogger{},
 	}
 	for _, opt := range opts {
 		opt(cfg)
@@ -128,10 +484,59 @@ func NewDialer(ctx context.Context, opts ...Option) (*Dialer, error) {
 			return nil, cfg.err
 		}
 	}
+	if cfg.httpProxy != nil && (cfg.dialFunc != nil || cfg.infoDialFunc != nil) {
+		return nil, errtype.NewConfigError(
+			"WithHTTPProxy cannot be used with WithDialFunc or WithContextDialFunc",
+			"n/a",
+		)
+	}
+	if cfg.insecureSkipVerify {
+		if os.Getenv(InsecureSkipVerifyEnv) != "true" {
+			return nil, errtype.NewConfigError(
+				fmt.Sprintf("WithInsecureSkipVerify requires the %v environment variable to be set to \"true\"",
+					InsecureSkipVerifyEnv),
+				"n/a",
+			)
+		}
+		cfg.logger.Debugf("Verification of server certificates is disabled, connections are not secure")
+	}
+	interval, burst := cfg.refreshInterval, cfg.refreshBurst
+	if interval == 0 {
+		interval, burst = alloydb.RefreshInterval, alloydb.RefreshBurst
+	}
+	if !cfg.lazyRefresh && !cfg.noRateLimit && cfg.refreshTimeout <= interval {
+		cfg.logger.Debugf(
+			"Refresh timeout (%v) is not greater than the refresh rate limit interval (%v), "+
+				"refreshes may fail when throttled by the rate limiter",
+			cfg.refreshTimeout, interval,
+		)
+	}
+	dialerID := cfg.dialerID
+	if dialerID == "" {
+		dialerID = uuid.New().String()
+	}
 	userAgent := strings.Join(cfg.userAgents, " ")
 	// Add this to the end to make sure it's not overridden
 	cfg.adminOpts = append(cfg.adminOpts, option.WithUserAgent(userAgent))
 
+	if cfg.rsaKey == nil && cfg.keyPath != "" {
+		bits := cfg.rsaKeySize
+		if bits == 0 {
+			bits = minRSAKeySize
+		}
+		key, err := loadOrGenerateKey(cfg.keyPath, bits, cfg.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA keys: %v", err)
+		}
+		cfg.rsaKey = key
+	}
+	if cfg.rsaKey == nil && cfg.rsaKeySize > 0 {
+		key, err := rsa.GenerateKey(rand.Reader, cfg.rsaKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA keys: %v", err)
+		}
+		cfg.rsaKey = key
+	}
 	if cfg.rsaKey == nil {
 		key, err := getDefaultKeys()
 		if err != nil {
@@ -140,8 +545,12 @@ func NewDialer(ctx context.Context, opts ...Option) (*Dialer, error) {
 		cfg.rsaKey = key
 	}
 
-	// If no token source is configured, use ADC's token source.
+	// If no token source is configured, use ADC's token source. With static
+	// connection info, a token is only needed for IAM authentication.
 	ts := cfg.tokenSource
+	if ts == nil && cfg.staticTLS != nil && !cfg.useIAMAuthN {
+		ts = oauth2.StaticTokenSource(&oauth2.Token{})
+	}
 	if ts == nil {
 		var err error
 		ts, err = google.DefaultTokenSource(ctx, CloudPlatformScope)
@@ -150,148 +559,1285 @@ func NewDialer(ctx context.Context, opts ...Option) (*Dialer, error) {
 		}
 	}
 
-	client, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, cfg.adminOpts...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AlloyDB Admin API client: %v", err)
-	}
+	// All tokens are retrieved through a replaceable token source, so that
+	// UpdateCredentials and the token source refresher can swap it.
+	iamTS := &refreshingTokenSource{
+		ts:      oauth2.ReuseTokenSource(nil, ts),
+		refresh: cfg.tokenSourceRefresher,
+		logger:  cfg.logger,
+	}
+	refreshErrFunc := cfg.refreshErrFunc
+	if cfg.tokenSourceRefresher != nil {
+		userErrFunc := refreshErrFunc
+		refreshErrFunc = func(instance string, err error) {
+			if isAuthError(err) {
+				iamTS.reset()
+			}
+			if userErrFunc != nil {
+				userErrFunc(instance, err)
+			}
+		}
+	}
+
+	// baseAdminOpts are the Admin API client options without credentials.
+	baseAdminOpts := cfg.adminOpts
+	var adminUsesIAMTS bool
+	switch {
+	case cfg.tokenSourceRefresher != nil:
+		// The Admin API client must use the replaceable token source.
+		cfg.adminOpts = append(cfg.adminOpts, option.WithTokenSource(iamTS))
+		adminUsesIAMTS = true
+	case cfg.credentials != nil:
+		cfg.adminOpts = append(cfg.adminOpts, option.WithCredentials(cfg.credentials))
+	case cfg.tokenSource != nil:
+		cfg.adminOpts = append(cfg.adminOpts, option.WithTokenSource(iamTS))
+		adminUsesIAMTS = true
+	}
+
+	var (
+		client    *alloydbadmin.AlloyDBAdminClient
+		newClient func() (*alloydbadmin.AlloyDBAdminClient, error)
+	)
+	switch {
+	case cfg.adminClient != nil:
+		client = cfg.adminClient
+	case cfg.staticTLS != nil:
+		// The Admin API is never called.
+	case cfg.lazyClient:
+		adminOpts, transport := cfg.adminOpts, cfg.adminTransport
+		newClient = func() (*alloydbadmin.AlloyDBAdminClient, error) {
+			return newAdminClient(context.Background(), transport, adminOpts...)
+		}
+	default:
+		var err error
+		client, err = newAdminClient(ctx, cfg.adminTransport, cfg.adminOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AlloyDB Admin API client: %v", err)
+		}
+	}
+
+	dialCfg := dialCfg{
+		ipType:       alloydb.PrivateIP,
+		tcpKeepAlive: defaultTCPKeepAlive,
+	}
+	for _, opt := range cfg.dialOpts {
+		opt(&dialCfg)
+	}
+
+	var refreshOpts []alloydb.Option
+	if refreshErrFunc != nil {
+		refreshOpts = append(refreshOpts, alloydb.WithRefreshErrorHandler(refreshErrFunc))
+	}
+	if cfg.refreshBuffer > 0 {
+		refreshOpts = append(refreshOpts, alloydb.WithRefreshBuffer(cfg.refreshBuffer))
+	}
+	if cfg.refreshInterval > 0 {
+		refreshOpts = append(refreshOpts, alloydb.WithRefreshRateLimit(cfg.refreshInterval, cfg.refreshBurst))
+	}
+	if cfg.noRateLimit {
+		refreshOpts = append(refreshOpts, alloydb.WithNoRefreshRateLimit())
+	}
+	if cfg.refreshJitter > 0 {
+		refreshOpts = append(refreshOpts, alloydb.WithRefreshJitter(cfg.refreshJitter))
+	}
+	if cfg.failureBackoffMin > 0 {
+		refreshOpts = append(refreshOpts,
+			alloydb.WithRefreshFailureBackoff(cfg.failureBackoffMin, cfg.failureBackoffMax))
+	}
+	if cfg.metadataTTL > 0 {
+		refreshOpts = append(refreshOpts, alloydb.WithMetadataTTL(cfg.metadataTTL))
+	}
+	if cfg.refreshRetry > 0 {
+		refreshOpts = append(refreshOpts, alloydb.WithRefreshRetry(cfg.refreshRetry))
+	}
+	if cfg.rootCAs != nil {
+		refreshOpts = append(refreshOpts, alloydb.WithRootCAs(cfg.rootCAs))
+	}
+	refreshOpts = append(refreshOpts, alloydb.WithContext(ctx))
+	if cfg.refresher != nil {
+		refreshOpts = append(refreshOpts, alloydb.WithRefreshFunc(refreshFunc(cfg.refresher)))
+	}
+	if cfg.maxRefreshes > 0 {
+		refreshOpts = append(refreshOpts,
+			alloydb.WithRefreshSemaphore(make(chan struct{}, cfg.maxRefreshes)))
+	}
+	if cfg.minTLSVersion != 0 {
+		refreshOpts = append(refreshOpts, alloydb.WithMinTLSVersion(cfg.minTLSVersion))
+	}
+	if cfg.cipherSuites != nil {
+		refreshOpts = append(refreshOpts, alloydb.WithCipherSuites(cfg.cipherSuites))
+	}
+	if cfg.csrTemplate != nil {
+		refreshOpts = append(refreshOpts, alloydb.WithCSRTemplate(cfg.csrTemplate))
+	}
+	var refreshSpacing time.Duration
+	if cfg.refreshBudget > 0 {
+		refreshSpacing = time.Minute / time.Duration(cfg.refreshBudget)
+		refreshOpts = append(refreshOpts, alloydb.WithRefreshBudget(rate.NewLimiter(
+			rate.Every(refreshSpacing), cfg.refreshBudget,
+		)))
+	}
+
+	// Lazy refreshes are not rate limited.
+	if cfg.lazyRefresh || cfg.noRateLimit {
+		interval, burst = 0, 0
+	}
+
+	if err := trace.InitMetrics(); err != nil {
+		return nil, err
+	}
+	d := &Dialer{
+		instances:          make(map[alloydb.InstanceURI]connectionInfoCache),
+		key:                cfg.rsaKey,
+		refreshTimeout:     cfg.refreshTimeout,
+		dialTimeout:        cfg.dialTimeout,
+		refreshOpts:        refreshOpts,
+		client:             client,
+		newClient:          newClient,
+		defaultDialCfg:     dialCfg,
+		dialerID:           dialerID,
+		dialFunc:           cfg.dialFunc,
+		infoDialFunc:       cfg.infoDialFunc,
+		httpProxy:          cfg.httpProxy,
+		onConnect:          cfg.onConnect,
+		onDisconnect:       cfg.onDisconnect,
+		lazyRefresh:        cfg.lazyRefresh,
+		staticAddr:         cfg.staticAddr,
+		staticTLS:          cfg.staticTLS,
+		closeAtCertExpiry:  cfg.closeAtCertExpiry,
+		insecureSkipVerify: cfg.insecureSkipVerify,
+		ipVersion:          cfg.ipVersion,
+		refreshInterval:    interval,
+		refreshBurst:       burst,
+		maxConns:           cfg.maxConns,
+		maxInstances:       cfg.maxInstances,
+		idleTimeout:        cfg.idleTimeout,
+		lastUsed:           make(map[alloydb.InstanceURI]instanceUse),
+		now:                time.Now,
+		useIAMAuthN:        cfg.useIAMAuthN,
+		iamTokenSource:     iamTS,
+		baseAdminOpts:      baseAdminOpts,
+		adminTransport:     cfg.adminTransport,
+		adminUsesIAMTS:     adminUsesIAMTS,
+		sharedClient:       cfg.adminClient != nil,
+		userAgent:          userAgent,
+		logger:             cfg.logger,
+		buffer:             newBuffer(),
+		refreshSpacing:     refreshSpacing,
+		forcedRefreshes:    make(map[alloydb.InstanceURI]*time.Timer),
+	}
+	// The refreshes of every instance are counted on the Dialer, and their
+	// events published to its subscribers.
+	d.events = &refreshEvents{subs: make(map[chan RefreshEvent]struct{})}
+	d.refreshOpts = append(d.refreshOpts,
+		alloydb.WithRefreshCounters(&d.refreshes, &d.refreshErrs),
+		alloydb.WithRefreshEvents(d.events.publish),
+	)
+	if d.idleTimeout > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.stopSweeper = cancel
+		d.sweeperDone = make(chan struct{})
+		go d.sweepIdle(ctx)
+	}
+	return d, nil
+}
+
+// Dial returns a net.Conn connected to the specified AlloyDB instance. The
+// instance argument must be the instance's URI, which is in the format
+// projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>
+// or in the short format <PROJECT>:<REGION>:<CLUSTER>:<INSTANCE>.
+//
+// The context bounds the whole call, including waiting on the first refresh
+// of the instance's connection info. If the context ends first, Dial returns
+// the context's error, while the refresh continues in the background, bounded
+// by the refresh timeout.
+func (d *Dialer) Dial(ctx context.Context, instance string, opts ...DialOption) (conn net.Conn, err error) {
+	startTime := time.Now()
+	cfg := d.defaultDialCfg
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var endDial trace.EndSpanFunc
+	ctx, endDial = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn.Dial",
+		append([]trace.Attribute{
+			trace.AddInstanceName(instance),
+			trace.AddDialerID(d.dialerID),
+		}, trace.AddLabels(cfg.labels)...)...,
+	)
+	ctx = trace.WithLabels(ctx, cfg.labels)
+	var inst alloydb.InstanceURI
+	defer func() {
+		// Tag metrics with the parsed instance, as the other metrics are,
+		// unless the instance URI is invalid.
+		name := instance
+		if inst != (alloydb.InstanceURI{}) {
+			name = inst.String()
+		}
+		mctx := trace.WithLabels(context.Background(), cfg.labels)
+		go trace.RecordDialAttempt(mctx, name, d.dialerID)
+		go trace.RecordDialError(mctx, name, d.dialerID, err)
+		endDial(err)
+		atomic.AddUint64(&d.dials, 1)
+		if err != nil {
+			atomic.AddUint64(&d.dialErrs, 1)
+		}
+	}()
+	// Count the dial as in flight before checking whether the Dialer is
+	// closing, so DrainAndClose waits for it.
+	atomic.AddInt64(&d.dialing, 1)
+	defer atomic.AddInt64(&d.dialing, -1)
+	if atomic.LoadInt32(&d.closing) == 1 {
+		return nil, errtype.NewDialError("failed to dial", instance, ErrDialerClosing)
+	}
+	inst, err = alloydb.ParseInstURI(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	var endInfo trace.EndSpanFunc
+	ctx, endInfo = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn/internal.InstanceInfo")
+	i, err := d.instance(inst, cfg.refreshTimeout)
+	if err != nil {
+		endInfo(err)
+		return nil, err
+	}
+	if cfg.failFast && !i.IsValid() {
+		if d.lazyRefresh {
+			// A lazy cache only refreshes when asked for connection info, so
+			// start a refresh for later calls.
+			go func() { _, _, _ = i.ConnectInfo(context.Background(), cfg.ipType) }()
+		}
+		err = errtype.NewDialError("failed to dial", inst.String(), ErrConnectionInfoUnavailable)
+		endInfo(err)
+		return nil, err
+	}
+	cacheHit := i.IsValid()
+	ipTypes := []string{cfg.ipType}
+	if len(cfg.ipFallback) > 0 {
+		ipTypes = cfg.ipFallback
+	}
+	cands, err := d.candidates(ctx, inst, i, ipTypes, cfg.tlsServerName)
+	if err != nil {
+		// Other errors (e.g., the caller's context ended before an ongoing
+		// refresh completed, or a transient Admin API error) keep the
+		// instance cached, so later calls can use a successful refresh.
+		d.removeIfNotFound(inst, i, err)
+		endInfo(err)
+		return nil, err
+	}
+	endInfo(err)
+
+	// If the client certificate has expired (as when the computer goes to
+	// sleep, and the refresh cycle cannot run), force a refresh immediately.
+	// The TLS handshake will not fail on an expired client certificate. It's
+	// not until the first read where the client cert error will be surfaced.
+	// So check that the certificate is valid before proceeding.
+	if invalidClientCert(cands[0].tlsCfg) {
+		d.logger.Debugf("[%v] Client certificate has expired, forcing refresh", inst.String())
+		cacheHit = false
+		i.ForceRefresh()
+		// Block on refreshed connection info
+		cands, err = d.candidates(ctx, inst, i, ipTypes, cfg.tlsServerName)
+		if err != nil {
+			d.removeIfNotFound(inst, i, err)
+			return nil, err
+		}
+	}
+
+	if cfg.preDial != nil {
+		if err := cfg.preDial(ctx, inst); err != nil {
+			return nil, errtype.NewDialError("dial vetoed by pre-dial hook", inst.String(), err)
+		}
+	}
+
+	// Reserve a connection slot before dialing, so concurrent dials can't
+	// exceed the limit. The slot is released if the dial fails.
+	if !acquireConn(i.OpenConns(), i.MaxOpenConns(), d.maxConns) {
+		return nil, errtype.NewDialError("failed to dial", inst.String(), ErrMaxConnections)
+	}
+	defer func() {
+		if err != nil {
+			atomic.AddUint64(i.OpenConns(), ^uint64(0))
+		}
+	}()
+
+	var connectEnd trace.EndSpanFunc
+	ctx, connectEnd = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn/internal.Connect")
+	defer func() { connectEnd(err) }()
+	dialFor := func(c dialCandidate) func(context.Context, string, string) (net.Conn, error) {
+		switch {
+		case cfg.dialFunc != nil:
+			return cfg.dialFunc
+		case d.infoDialFunc != nil:
+			return func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.infoDialFunc(ctx, DialInfo{
+					Instance:  inst,
+					IPAddress: c.addr,
+					IPType:    c.ipType,
+				})
+			}
+		case d.dialFunc != nil:
+			return d.dialFunc
+		case d.httpProxy != nil:
+			return httpProxyDialFunc(d.httpProxy, cfg.tcpKeepAlive)
+		}
+		return defaultDialFunc(cfg.tcpKeepAlive)
+	}
+	tlsConn, cand, err := d.dialFirst(ctx, inst, i, cands, dialFor, cfg.tcpKeepAlive)
+	if err != nil && isVerifyError(err) && ctx.Err() == nil {
+		// The certificates may have been rotated between retrieving the
+		// connection info and the handshake. dialTLS has already forced a
+		// refresh, so block on the refreshed connection info and retry once.
+		d.logger.Debugf("[%v] Certificate verification failed, retrying with refreshed connection info", inst.String())
+		cacheHit = false
+		cands, err = d.candidates(ctx, inst, i, ipTypes, cfg.tlsServerName)
+		if err != nil {
+			d.removeIfNotFound(inst, i, err)
+			return nil, err
+		}
+		tlsConn, cand, err = d.dialFirst(ctx, inst, i, cands, dialFor, cfg.tcpKeepAlive)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The metadata exchange must occur after the TLS connection is established
+	// to avoid leaking sensitive information.
+	useIAMAuthN := d.useIAMAuthN
+	if cfg.iamAuthN != nil {
+		useIAMAuthN = *cfg.iamAuthN
+	}
+	err = d.metadataExchange(tlsConn, useIAMAuthN)
+	if err != nil {
+		_ = tlsConn.Close() // best effort close attempt
+		return nil, err
+	}
+
+	latency := time.Since(startTime).Milliseconds()
+	go func() {
+		n := atomic.LoadUint64(i.OpenConns())
+		trace.RecordOpenConnections(ctx, int64(n), d.dialerID, inst.String())
+		trace.RecordDialLatency(ctx, inst.String(), d.dialerID, latency)
+	}()
+
+	tlsCfg := cand.tlsCfg
+	if cfg.result != nil {
+		*cfg.result = DialResult{IPAddress: cand.addr, IPType: cand.ipType, CacheHit: cacheHit}
+		if len(tlsCfg.Certificates) > 0 && tlsCfg.Certificates[0].Leaf != nil {
+			cfg.result.CertExpiry = tlsCfg.Certificates[0].Leaf.NotAfter
+		}
+	}
+	if d.onConnect != nil {
+		d.onConnect(inst)
+	}
+	openedAt := time.Now()
+	iConn := newInstrumentedConn(tlsConn, inst.URI(), cand.ipType, func() {
+		n := atomic.AddUint64(i.OpenConns(), ^uint64(0))
+		trace.RecordOpenConnections(context.Background(), int64(n), d.dialerID, inst.String())
+		if d.onDisconnect != nil {
+			d.onDisconnect(inst, time.Since(openedAt))
+		}
+	})
+	if d.closeAtCertExpiry && len(tlsCfg.Certificates) > 0 && tlsCfg.Certificates[0].Leaf != nil {
+		iConn.closeAt(tlsCfg.Certificates[0].Leaf.NotAfter)
+	}
+	return iConn, nil
+}
+
+// DialContext connects to the AlloyDB instance at addr as Dial does with the
+// default DialOptions, so that the Dialer can be used wherever the signature
+// of net.Dialer's DialContext is expected (e.g., by connection pools). addr
+// is the instance's URI, optionally joined with a port (e.g., by a driver
+// that joins the host and port), which is ignored. network must be "tcp",
+// "tcp4", "tcp6", or empty, and is otherwise ignored, as the IP type selects
+// the address.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errtype.NewConfigError(
+			fmt.Sprintf("unsupported network %q, expected tcp", network), addr,
+		)
+	}
+	if _, err := alloydb.ParseInstURI(addr); err != nil {
+		if host, _, serr := net.SplitHostPort(addr); serr == nil {
+			addr = host
+		}
+	}
+	return d.Dial(ctx, addr)
+}
+
+// dialFailures tracks recent failed dials to each instance.
+type dialFailures struct {
+	mu sync.Mutex
+	m  map[alloydb.InstanceURI]*dialFailureState
+}
+
+type dialFailureState struct {
+	// count is the number of failures since windowStart.
+	count       int
+	windowStart time.Time
+	// lastForced is when a refresh was last forced.
+	lastForced time.Time
+}
+
+// record records a failed dial to inst and reports whether to force a
+// refresh, which is the case once dialFailureThreshold dials failed within
+// dialFailureWindow, unless a refresh was already forced within the window.
+func (f *dialFailures) record(inst alloydb.InstanceURI, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m == nil {
+		f.m = make(map[alloydb.InstanceURI]*dialFailureState)
+	}
+	s, ok := f.m[inst]
+	if !ok {
+		s = &dialFailureState{}
+		f.m[inst] = s
+	}
+	if now.Sub(s.windowStart) >= dialFailureWindow {
+		s.windowStart = now
+		s.count = 0
+	}
+	s.count++
+	if s.count < dialFailureThreshold {
+		return false
+	}
+	if !s.lastForced.IsZero() && now.Sub(s.lastForced) < dialFailureWindow {
+		return false
+	}
+	s.lastForced = now
+	s.count = 0
+	return true
+}
+
+// reset forgets the failed dials to inst after a successful dial.
+func (f *dialFailures) reset(inst alloydb.InstanceURI) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.m, inst)
+}
+
+// fallbackDelay is how long Dial waits for a connection to an address of an
+// instance before also connecting to the next one, as recommended by RFC 8305.
+const fallbackDelay = 300 * time.Millisecond
+
+// dialCandidate is an address of an instance that Dial may connect to.
+type dialCandidate struct {
+	ipType string
+	addr   string
+	tlsCfg *tls.Config
+}
+
+// candidates returns the addresses of the instance of the provided IP types,
+// in order, that Dial may connect to. IP types that the instance has no usable
+// address of are skipped, unless there are none left, in which case the error
+// of the first IP type is returned. If serverName is set, it overrides the
+// server name used to verify the instance's certificate.
+func (d *Dialer) candidates(
+	ctx context.Context,
+	inst alloydb.InstanceURI,
+	i connectionInfoCache,
+	ipTypes []string,
+	serverName string,
+) ([]dialCandidate, error) {
+	var (
+		cands    []dialCandidate
+		firstErr error
+	)
+	for _, t := range ipTypes {
+		addr, tlsCfg, err := i.ConnectInfo(ctx, t)
+		if err == nil {
+			err = checkIPVersion(inst, t, addr, d.ipVersion)
+		}
+		if err != nil {
+			if !i.IsValid() {
+				// The refresh failed, so every IP type fails the same way.
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if serverName != "" {
+			tlsCfg.ServerName = serverName
+		}
+		cands = append(cands, dialCandidate{ipType: t, addr: addr, tlsCfg: tlsCfg})
+	}
+	if len(cands) == 0 {
+		return nil, firstErr
+	}
+	return cands, nil
+}
+
+// dialFirst connects to the first of the candidates that accepts the
+// connection, as in Happy Eyeballs (RFC 8305): it dials the candidates in
+// order, starting the next one once the previous one failed or fallbackDelay
+// has passed. The connections that lose the race are closed. dialFor returns
+// the function used to connect to a candidate. If every candidate fails,
+// dialFirst returns the error of the first one.
+func (d *Dialer) dialFirst(
+	ctx context.Context,
+	inst alloydb.InstanceURI,
+	i connectionInfoCache,
+	cands []dialCandidate,
+	dialFor func(dialCandidate) func(context.Context, string, string) (net.Conn, error),
+	keepAlive time.Duration,
+) (*tls.Conn, dialCandidate, error) {
+	if len(cands) == 1 {
+		c := cands[0]
+		conn, err := d.dialTLS(ctx, inst, i, dialFor(c), c.addr, c.tlsCfg, keepAlive)
+		return conn, c, err
+	}
+
+	// Stop the dials that lose the race. An established connection isn't
+	// affected.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn *tls.Conn
+		c    dialCandidate
+		err  error
+	}
+	results := make(chan result, len(cands))
+	next, pending := 0, 0
+	var fallback <-chan time.Time
+	start := func() {
+		c := cands[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.dialTLS(ctx, inst, i, dialFor(c), c.addr, c.tlsCfg, keepAlive)
+			results <- result{conn: conn, c: c, err: err}
+		}()
+		fallback = nil
+		if next < len(cands) {
+			fallback = time.After(fallbackDelay)
+		}
+	}
+	start()
+	var firstErr error
+	for {
+		select {
+		case <-fallback:
+			d.logger.Debugf("[%v] Connecting to %v address is slow, also connecting to %v address",
+				inst.String(), cands[next-1].ipType, cands[next].ipType)
+			start()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							_ = r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.c, nil
+			}
+			if firstErr == nil || r.c.ipType == cands[0].ipType {
+				firstErr = r.err
+			}
+			if next < len(cands) {
+				d.logger.Debugf("[%v] Connecting to %v address failed, connecting to %v address, err = %v",
+					inst.String(), r.c.ipType, cands[next].ipType, r.err)
+				start()
+			} else if pending == 0 {
+				return nil, dialCandidate{}, firstErr
+			}
+		}
+	}
+}
+
+// dialTLS connects to the server proxy at ipAddr and completes the TLS
+// handshake. On a failed handshake, or repeated failures to connect, dialTLS
+// forces a refresh of the instance's connection info in case it caused the
+// failure.
+func (d *Dialer) dialTLS(
+	ctx context.Context,
+	inst alloydb.InstanceURI,
+	i connectionInfoCache,
+	f func(context.Context, string, string) (net.Conn, error),
+	ipAddr string,
+	tlsCfg *tls.Config,
+	keepAlive time.Duration,
+) (*tls.Conn, error) {
+	if d.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.dialTimeout)
+		defer cancel()
+	}
+	conn, err := f(ctx, "tcp", net.JoinHostPort(ipAddr, serverProxyPort))
+	if err != nil {
+		// Repeated failures may mean the instance's IP address changed
+		// (e.g., during maintenance), so refresh the instance info.
+		if d.dialFailures.record(inst, d.now()) {
+			d.logger.Debugf("[%v] Repeated dial failures, forcing refresh, err = %v", inst.String(), err)
+			i.ForceRefresh()
+		} else {
+			d.logger.Debugf("[%v] Dial failed, err = %v", inst.String(), err)
+		}
+		return nil, errtype.NewDialError("failed to dial", inst.String(), err)
+	}
+	d.dialFailures.reset(inst)
+	if c, ok := conn.(*net.TCPConn); ok {
+		if err := c.SetKeepAlive(true); err != nil {
+			_ = conn.Close()
+			return nil, errtype.NewDialError("failed to set keep-alive", inst.String(), err)
+		}
+		if err := c.SetKeepAlivePeriod(keepAlive); err != nil {
+			_ = conn.Close()
+			return nil, errtype.NewDialError("failed to set keep-alive period", inst.String(), err)
+		}
+	}
+
+	if d.insecureSkipVerify {
+		tlsCfg.InsecureSkipVerify = true
+	}
+	tlsConn := tls.Client(conn, tlsCfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		// refresh the instance info in case it caused the handshake failure
+		d.logger.Debugf("[%v] TLS handshake failed, forcing refresh, err = %v", inst.String(), err)
+		i.ForceRefresh()
+		_ = tlsConn.Close() // best effort close attempt
+		if isVerifyError(err) {
+			return nil, errtype.NewTLSError(
+				"failed to verify server certificate", inst.String(), err,
+			)
+		}
+		return nil, errtype.NewDialError("handshake failed", inst.String(), err)
+	}
+	return tlsConn, nil
+}
+
+// Warmup retrieves the connection info for the specified AlloyDB instance and
+// stores it in the Dialer's cache, blocking until the first refresh completes.
+// No connection to the instance is opened. Use Warmup to avoid waiting on the
+// AlloyDB Admin API during the first call to Dial. Warmup returns the same
+// errors Dial would return when retrieving the connection info.
+func (d *Dialer) Warmup(ctx context.Context, instance string, opts ...DialOption) error {
+	cfg := d.defaultDialCfg
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return err
+	}
+	i, err := d.instance(inst, cfg.refreshTimeout)
+	if err != nil {
+		return err
+	}
+	_, _, err = i.ConnectInfo(ctx, cfg.ipType)
+	if err != nil {
+		d.removeIfNotFound(inst, i, err)
+	}
+	return err
+}
+
+// Probe verifies end-to-end connectivity to the specified AlloyDB instance:
+// it dials the instance as Dial does, which completes the TLS handshake and
+// the metadata exchange with the server side proxy, and then closes the
+// connection. Unlike Warmup, Probe catches network and server side proxy
+// failures, so it is suited to deep health checks. No query is run, as that
+// requires a database driver. Probe returns the same errors Dial would, or a
+// DialError if the connection fails to close. The probe connection no longer
+// counts as open by the time Probe returns.
+func (d *Dialer) Probe(ctx context.Context, instance string, opts ...DialOption) error {
+	conn, err := d.Dial(ctx, instance, opts...)
+	if err != nil {
+		return err
+	}
+	ic := conn.(*instrumentedConn)
+	if ic.expiry != nil {
+		ic.expiry.Stop()
+	}
+	err = ic.Conn.Close()
+	// Report the close synchronously, even if it failed, as the connection is
+	// never used again.
+	ic.closeOnce.Do(ic.closeFunc)
+	if err != nil {
+		return errtype.NewDialError("failed to close probe connection", ic.instance, err)
+	}
+	return nil
+}
+
+// WarmupAll warms up each of the specified AlloyDB instances as Warmup does,
+// concurrently, and waits for all of them. Unlike Warmup, it doesn't stop at
+// the first failure: the returned error joins the errors of every instance
+// that failed, in the order of instances, so callers can inspect each failure
+// with errors.As. WarmupAll returns nil if every instance was warmed up.
+func (d *Dialer) WarmupAll(ctx context.Context, instances []string, opts ...DialOption) error {
+	errs := make([]error, len(instances))
+	var wg sync.WaitGroup
+	for n, instance := range instances {
+		wg.Add(1)
+		go func(n int, instance string) {
+			defer wg.Done()
+			errs[n] = d.Warmup(ctx, instance, opts...)
+		}(n, instance)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// CertExpiry returns the expiration time of the client certificate Dial uses
+// for new connections to the instance, e.g., to bound connection lifetimes in
+// a pool.
+func (d *Dialer) CertExpiry(ctx context.Context, instance string, opts ...DialOption) (time.Time, error) {
+	cfg := d.defaultDialCfg
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return time.Time{}, err
+	}
+	i, err := d.instance(inst, cfg.refreshTimeout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, _, expiry, err := i.ConnectInfoWithExpiry(ctx, cfg.ipType)
+	if err != nil {
+		d.removeIfNotFound(inst, i, err)
+		return time.Time{}, err
+	}
+	return expiry, nil
+}
+
+// ConnectionInfo describes the connection info Dial currently uses for new
+// connections to an AlloyDB instance.
+type ConnectionInfo struct {
+	// IPAddress is the IP address of the instance.
+	IPAddress string
+	// IPType is the type of IPAddress, e.g., PrivateIP.
+	IPType IPType
+	// CertExpiry is the expiration time of the client certificate.
+	CertExpiry time.Time
+}
+
+// ConnectionInfo returns the connection info Dial uses for new connections to
+// the instance without opening a connection, e.g., to monitor the instance.
+func (d *Dialer) ConnectionInfo(ctx context.Context, instance string, opts ...DialOption) (ConnectionInfo, error) {
+	cfg := d.defaultDialCfg
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return ConnectionInfo{}, err
+	}
+	i, err := d.instance(inst, cfg.refreshTimeout)
+	if err != nil {
+		return ConnectionInfo{}, err
+	}
+	addr, _, expiry, err := i.ConnectInfoWithExpiry(ctx, cfg.ipType)
+	if err != nil {
+		d.removeIfNotFound(inst, i, err)
+		return ConnectionInfo{}, err
+	}
+	return ConnectionInfo{
+		IPAddress:  addr,
+		IPType:     IPType(cfg.ipType),
+		CertExpiry: expiry,
+	}, nil
+}
+
+// ForceRefresh immediately refreshes the cached connection info of the
+// instance, e.g., after rotating the cluster's CA.
+func (d *Dialer) ForceRefresh(instance string) error {
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return err
+	}
+	d.lock.RLock()
+	i, ok := d.instances[inst]
+	d.lock.RUnlock()
+	if !ok {
+		return errtype.NewConfigError("instance has not been dialed", inst.String())
+	}
+	d.logger.Debugf("[%v] Forcing refresh", inst.String())
+	i.ForceRefresh()
+	return nil
+}
+
+// ForceRefreshAll immediately refreshes the cached connection info of every
+// instance the Dialer has dialed (or warmed up), e.g., after a fleet-wide CA
+// rotation, as ForceRefresh does for each. Each instance's refresh is subject
+// to its own rate limit. If a refresh budget is configured (see
+// WithRefreshBudget), the refreshes are spread out evenly so that they stay
+// within the budget, and ForceRefreshAll returns before all of them have
+// started. Refreshes that have yet to start are dropped if their instance is
+// removed from the cache (e.g., with RemoveInstance) or the Dialer is closed.
+func (d *Dialer) ForceRefreshAll() {
+	d.lock.Lock()
+	if d.forcedRefreshes == nil {
+		// The Dialer is closed.
+		d.lock.Unlock()
+		return
+	}
+	insts := make([]alloydb.InstanceURI, 0, len(d.instances))
+	for inst := range d.instances {
+		insts = append(insts, inst)
+	}
+	sort.Slice(insts, func(a, b int) bool { return insts[a].URI() < insts[b].URI() })
+
+	var now []connectionInfoCache
+	for n, inst := range insts {
+		i := d.instances[inst]
+		delay := time.Duration(n) * d.refreshSpacing
+		d.logger.Debugf("[%v] Forcing refresh in %v", inst.String(), delay)
+		// A refresh forced now supersedes one still pending.
+		d.stopForcedRefreshLocked(inst)
+		if delay == 0 {
+			now = append(now, i)
+			continue
+		}
+		var t *time.Timer
+		t = time.AfterFunc(delay, func() {
+			d.lock.Lock()
+			if d.forcedRefreshes[inst] == t {
+				delete(d.forcedRefreshes, inst)
+			}
+			// The instance may have been removed from the cache (and
+			// closed) or replaced in the meantime.
+			cur := d.instances[inst]
+			d.lock.Unlock()
+			if cur == i {
+				i.ForceRefresh()
+			}
+		})
+		d.forcedRefreshes[inst] = t
+	}
+	d.lock.Unlock()
+	for _, i := range now {
+		i.ForceRefresh()
+	}
+}
+
+// stopForcedRefreshLocked stops the refresh of the provided instance that
+// ForceRefreshAll has yet to force, if any. The caller must hold lock.
+func (d *Dialer) stopForcedRefreshLocked(inst alloydb.InstanceURI) {
+	if t, ok := d.forcedRefreshes[inst]; ok {
+		t.Stop()
+		delete(d.forcedRefreshes, inst)
+	}
+}
+
+// WaitForRefresh blocks until the next refresh of the instance completes,
+// e.g., one started with ForceRefresh, and returns its error. Unforced
+// refreshes may be hours away (or, with WithLazyRefresh, run by the call), so
+// pass a ctx with a deadline.
+func (d *Dialer) WaitForRefresh(ctx context.Context, instance string) error {
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return err
+	}
+	d.lock.RLock()
+	i, ok := d.instances[inst]
+	d.lock.RUnlock()
+	if !ok {
+		return errtype.NewConfigError("instance has not been dialed", inst.String())
+	}
+	return i.WaitForRefresh(ctx)
+}
+
+// RemoveInstance stops refreshing the instance and removes it from the cache,
+// e.g., after it was decommissioned. It fails if the instance has open
+// connections.
+func (d *Dialer) RemoveInstance(instance string) error {
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	i, ok := d.instances[inst]
+	if !ok {
+		d.lock.Unlock()
+		return errtype.NewConfigError("instance has not been dialed", inst.String())
+	}
+	if n := atomic.LoadUint64(i.OpenConns()); n > 0 {
+		d.lock.Unlock()
+		return errtype.NewConfigError(
+			fmt.Sprintf("instance has %d open connections", n), inst.String(),
+		)
+	}
+	delete(d.instances, inst)
+	delete(d.lastUsed, inst)
+	d.stopForcedRefreshLocked(inst)
+	d.lock.Unlock()
+	d.logger.Debugf("[%v] Removing instance", inst.String())
+	// Close without holding the lock, as waiting on an in-flight refresh
+	// may take up to the refresh timeout.
+	return i.Close()
+}
+
+// RefreshInProgress is returned by CurrentRefreshAge when the refresh
+// providing an instance's connection info has not completed yet.
+const RefreshInProgress = alloydb.RefreshInProgress
+
+// CurrentRefreshAge reports how long ago the refresh providing the instance's
+// current connection info completed, or RefreshInProgress, without waiting,
+// e.g., to alert on stuck refreshes.
+func (d *Dialer) CurrentRefreshAge(instance string) (time.Duration, error) {
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return 0, err
+	}
+	d.lock.RLock()
+	i, ok := d.instances[inst]
+	d.lock.RUnlock()
+	if !ok {
+		return 0, errtype.NewConfigError("instance has not been dialed", inst.String())
+	}
+	return i.CurrentRefreshAge(), nil
+}
+
+// MaxOpenConns reports the highest number of connections to the instance
+// open at the same time since it was cached, e.g., for capacity planning.
+func (d *Dialer) MaxOpenConns(instance string) (uint64, error) {
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return 0, err
+	}
+	d.lock.RLock()
+	i, ok := d.instances[inst]
+	d.lock.RUnlock()
+	if !ok {
+		return 0, errtype.NewConfigError("instance has not been dialed", inst.String())
+	}
+	return atomic.LoadUint64(i.MaxOpenConns()), nil
+}
+
+// InstanceHealth describes the state of the cached connection info of an
+// AlloyDB instance.
+type InstanceHealth struct {
+	// LastRefresh is the time of the most recent successful refresh, or the
+	// zero time if no refresh has succeeded.
+	LastRefresh time.Time
+	// CertExpiry is the expiration time of the client certificate used for
+	// new connections, or the zero time if there is none.
+	CertExpiry time.Time
+	// LastRefreshErr is the error of the most recent refresh, or nil if it
+	// succeeded.
+	LastRefreshErr error
+	// OpenConns is the number of open connections to the instance.
+	OpenConns uint64
+}
+
+// ReportHealth reports the state of every cached instance, keyed by instance
+// URI (see InstanceURI.String), e.g., for readiness probes.
+func (d *Dialer) ReportHealth() map[string]InstanceHealth {
+	d.lock.RLock()
+	caches := make(map[alloydb.InstanceURI]connectionInfoCache, len(d.instances))
+	for inst, i := range d.instances {
+		caches[inst] = i
+	}
+	d.lock.RUnlock()
+
+	health := make(map[string]InstanceHealth, len(caches))
+	for inst, i := range caches {
+		h := i.Health()
+		health[inst.URI()] = InstanceHealth{
+			LastRefresh:    h.LastRefresh,
+			CertExpiry:     h.CertExpiry,
+			LastRefreshErr: h.LastErr,
+			OpenConns:      h.OpenConns,
+		}
+	}
+	return health
+}
+
+// DialerStats aggregates the activity of a Dialer across all instances.
+type DialerStats struct {
+	// Instances is the number of instances whose connection info is cached.
+	Instances int
+	// OpenConns is the number of open connections to the cached instances.
+	OpenConns uint64
+	// Dials is the number of calls to Dial, and DialErrors the number of
+	// those that failed.
+	Dials      uint64
+	DialErrors uint64
+	// Refreshes is the number of refreshes of connection info, and
+	// RefreshErrors the number of those that failed.
+	Refreshes     uint64
+	RefreshErrors uint64
+}
+
+// Stats reports the activity of the Dialer aggregated across all instances,
+// e.g., for a service multiplexing connections to many instances. The
+// counters include instances that are no longer cached, while OpenConns
+// counts the connections to the instances that are currently cached. Stats
+// doesn't block on refreshes or dials.
+func (d *Dialer) Stats() DialerStats {
+	d.lock.RLock()
+	caches := make([]connectionInfoCache, 0, len(d.instances))
+	for _, i := range d.instances {
+		caches = append(caches, i)
+	}
+	d.lock.RUnlock()
+
+	s := DialerStats{
+		Instances:     len(caches),
+		Dials:         atomic.LoadUint64(&d.dials),
+		DialErrors:    atomic.LoadUint64(&d.dialErrs),
+		Refreshes:     atomic.LoadUint64(&d.refreshes),
+		RefreshErrors: atomic.LoadUint64(&d.refreshErrs),
+	}
+	for _, i := range caches {
+		s.OpenConns += atomic.LoadUint64(i.OpenConns())
+	}
+	return s
+}
+
+// RefreshEventType identifies a stage of the refresh of an instance's
+// connection info.
+type RefreshEventType string
+
+const (
+	// RefreshScheduled reports that a refresh was scheduled.
+	RefreshScheduled = RefreshEventType(alloydb.RefreshScheduled)
+	// RefreshStarted reports that a refresh started.
+	RefreshStarted = RefreshEventType(alloydb.RefreshStarted)
+	// RefreshSucceeded reports that a refresh succeeded.
+	RefreshSucceeded = RefreshEventType(alloydb.RefreshSucceeded)
+	// RefreshFailed reports that a refresh failed.
+	RefreshFailed = RefreshEventType(alloydb.RefreshFailed)
+)
+
+// RefreshEvent describes a stage of the refresh of an instance's connection
+// info.
+type RefreshEvent struct {
+	// Instance is the URI of the instance, in the format
+	// projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>.
+	Instance string
+	// Type is the stage of the refresh.
+	Type RefreshEventType
+	// Time is when the stage was reached.
+	Time time.Time
+	// NextRefresh is when a scheduled refresh starts. It's only set for
+	// RefreshScheduled.
+	NextRefresh time.Time
+	// Err is the error of a failed refresh. It's only set for RefreshFailed.
+	Err error
+}
+
+// refreshEventBuffer is the number of events buffered for each subscriber.
+const refreshEventBuffer = 64
 
-	dialCfg := dialCfg{
-		tcpKeepAlive: defaultTCPKeepAlive,
+// refreshEvents publishes refresh events to the subscribers of a Dialer.
+type refreshEvents struct {
+	mu   sync.RWMutex
+	subs map[chan RefreshEvent]struct{}
+}
+
+// publish sends e to every subscriber whose buffer isn't full. It never
+// blocks, so a slow subscriber can't delay refreshes.
+func (r *refreshEvents) publish(e alloydb.RefreshEvent) {
+	ev := RefreshEvent{
+		Instance:    e.Instance,
+		Type:        RefreshEventType(e.Type),
+		Time:        e.Time,
+		NextRefresh: e.NextRefresh,
+		Err:         e.Err,
 	}
-	for _, opt := range cfg.dialOpts {
-		opt(&dialCfg)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for ch := range r.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
 	}
+}
 
-	if err := trace.InitMetrics(); err != nil {
-		return nil, err
-	}
-	d := &Dialer{
-		instances:      make(map[alloydb.InstanceURI]connectionInfoCache),
-		key:            cfg.rsaKey,
-		refreshTimeout: cfg.refreshTimeout,
-		client:         client,
-		defaultDialCfg: dialCfg,
-		dialerID:       uuid.New().String(),
-		dialFunc:       cfg.dialFunc,
-		useIAMAuthN:    cfg.useIAMAuthN,
-		iamTokenSource: ts,
-		userAgent:      userAgent,
-		buffer:         newBuffer(),
+// Subscribe returns a channel of the events of every refresh of the Dialer's
+// instances (e.g., for a debugging dashboard) along with a function that
+// unsubscribes and closes the channel. The channel buffers a limited number
+// of events; events that arrive while the buffer is full are dropped, so a
+// slow consumer never delays refreshes. Instances refreshed lazily (see
+// WithLazyRefresh) don't schedule refreshes, so they emit no
+// RefreshScheduled events.
+func (d *Dialer) Subscribe() (<-chan RefreshEvent, func()) {
+	ch := make(chan RefreshEvent, refreshEventBuffer)
+	d.events.mu.Lock()
+	d.events.subs[ch] = struct{}{}
+	d.events.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			d.events.mu.Lock()
+			defer d.events.mu.Unlock()
+			delete(d.events.subs, ch)
+			close(ch)
+		})
 	}
-	return d, nil
 }
 
-// Dial returns a net.Conn connected to the specified AlloyDB instance. The
-// instance argument must be the instance's URI, which is in the format
-// projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>
-func (d *Dialer) Dial(ctx context.Context, instance string, opts ...DialOption) (conn net.Conn, err error) {
-	startTime := time.Now()
-	var endDial trace.EndSpanFunc
-	ctx, endDial = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn.Dial",
-		trace.AddInstanceName(instance),
-		trace.AddDialerID(d.dialerID),
-	)
-	defer func() {
-		go trace.RecordDialError(context.Background(), instance, d.dialerID, err)
-		endDial(err)
-	}()
-	cfg := d.defaultDialCfg
-	for _, opt := range opts {
-		opt(&cfg)
+// CachedInstances returns the sorted URIs (see InstanceURI.String) of the
+// cached instances, without triggering any refreshes.
+func (d *Dialer) CachedInstances() []string {
+	d.lock.RLock()
+	insts := make([]string, 0, len(d.instances))
+	for inst := range d.instances {
+		insts = append(insts, inst.String())
 	}
+	d.lock.RUnlock()
+	sort.Strings(insts)
+	return insts
+}
+
+// EngineVersion returns the database version of the instance (e.g.,
+// POSTGRES_15), caching it with the instance's connection info.
+func (d *Dialer) EngineVersion(ctx context.Context, instance string) (string, error) {
 	inst, err := alloydb.ParseInstURI(instance)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	var endInfo trace.EndSpanFunc
-	ctx, endInfo = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn/internal.InstanceInfo")
-	i, err := d.instance(inst)
+	i, err := d.instance(inst, 0)
 	if err != nil {
-		endInfo(err)
-		return nil, err
+		return "", err
 	}
-	addr, tlsCfg, err := i.ConnectInfo(ctx)
+	v, err := i.EngineVersion(ctx)
 	if err != nil {
-		d.lock.Lock()
-		defer d.lock.Unlock()
-		// Stop all background refreshes
-		i.Close()
-		delete(d.instances, inst)
-		endInfo(err)
-		return nil, err
+		d.removeIfNotFound(inst, i, err)
+		return "", err
 	}
-	endInfo(err)
+	return v, nil
+}
 
-	// If the client certificate has expired (as when the computer goes to
-	// sleep, and the refresh cycle cannot run), force a refresh immediately.
-	// The TLS handshake will not fail on an expired client certificate. It's
-	// not until the first read where the client cert error will be surfaced.
-	// So check that the certificate is valid before proceeding.
-	if invalidClientCert(tlsCfg) {
-		i.ForceRefresh()
-		// Block on refreshed connection info
-		addr, tlsCfg, err = i.ConnectInfo(ctx)
-		if err != nil {
-			d.lock.Lock()
-			defer d.lock.Unlock()
-			// Stop all background refreshes
-			i.Close()
-			delete(d.instances, inst)
-			return nil, err
-		}
-	}
+// CertificateInfo describes the ephemeral client certificate the Dialer uses
+// to connect to an AlloyDB instance. It never includes the private key.
+type CertificateInfo struct {
+	// Subject is the certificate's subject as an RFC 2253 distinguished name.
+	Subject string
+	// DNSNames, EmailAddresses, IPAddresses, and URIs are the certificate's
+	// subject alternative names.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []string
+	// NotBefore and NotAfter bound the certificate's validity window.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
 
-	var connectEnd trace.EndSpanFunc
-	ctx, connectEnd = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn/internal.Connect")
-	defer func() { connectEnd(err) }()
-	addr = net.JoinHostPort(addr, serverProxyPort)
-	f := d.dialFunc
-	if cfg.dialFunc != nil {
-		f = cfg.dialFunc
+// CertificateInfo returns a description of the client certificate used to
+// connect to the instance, e.g., for audit logs.
+func (d *Dialer) CertificateInfo(ctx context.Context, instance string) (CertificateInfo, error) {
+	inst, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		return CertificateInfo{}, err
 	}
-	conn, err = f(ctx, "tcp", addr)
+	i, err := d.instance(inst, 0)
 	if err != nil {
-		// refresh the instance info in case it caused the connection failure
-		i.ForceRefresh()
-		return nil, errtype.NewDialError("failed to dial", inst.String(), err)
+		return CertificateInfo{}, err
 	}
-	if c, ok := conn.(*net.TCPConn); ok {
-		if err := c.SetKeepAlive(true); err != nil {
-			return nil, errtype.NewDialError("failed to set keep-alive", inst.String(), err)
-		}
-		if err := c.SetKeepAlivePeriod(cfg.tcpKeepAlive); err != nil {
-			return nil, errtype.NewDialError("failed to set keep-alive period", inst.String(), err)
-		}
+	c, err := i.CertificateInfo(ctx)
+	if err != nil {
+		d.removeIfNotFound(inst, i, err)
+		return CertificateInfo{}, err
 	}
+	return CertificateInfo{
+		Subject:        c.Subject,
+		DNSNames:       c.DNSNames,
+		EmailAddresses: c.EmailAddresses,
+		IPAddresses:    c.IPAddresses,
+		URIs:           c.URIs,
+		NotBefore:      c.NotBefore,
+		NotAfter:       c.NotAfter,
+	}, nil
+}
 
-	tlsConn := tls.Client(conn, tlsCfg)
-	if err := tlsConn.HandshakeContext(ctx); err != nil {
-		// refresh the instance info in case it caused the handshake failure
-		i.ForceRefresh()
-		_ = tlsConn.Close() // best effort close attempt
-		return nil, errtype.NewDialError("handshake failed", inst.String(), err)
-	}
+// RefreshRateLimit reports the effective rate limit on refreshes of each
+// instance: one refresh every interval, with bursts of up to burst refreshes
+// (see WithRefreshRateLimit). It reports zero values if refreshes are not
+// rate limited, i.e., with WithNoRefreshRateLimit or WithLazyRefresh.
+func (d *Dialer) RefreshRateLimit() (interval time.Duration, burst int) {
+	return d.refreshInterval, d.refreshBurst
+}
 
-	// The metadata exchange must occur after the TLS connection is established
-	// to avoid leaking sensitive information.
-	err = d.metadataExchange(tlsConn)
+// UpdateCredentials replaces the credentials of the Dialer with ts, e.g.,
+// after credentials on disk have been rotated. Subsequent refreshes and
+// automatic IAM authentication use ts. Open connections are unaffected.
+//
+// If the Dialer's AlloyDB Admin API client was not created from a token source
+// (e.g., it uses WithCredentialsFile or Application Default Credentials),
+// UpdateCredentials creates a new client and drops the cached connection info
+// of all instances, so the next call to Dial for each instance waits on a
+// refresh. UpdateCredentials returns an error if the client was provided with
+// WithAdminClient, as the Dialer cannot change its credentials.
+func (d *Dialer) UpdateCredentials(ts oauth2.TokenSource) error {
+	if ts == nil {
+		return errtype.NewConfigError("token source must not be nil", "n/a")
+	}
+	if d.sharedClient {
+		return errtype.NewConfigError(
+			"cannot update the credentials of an admin client provided with WithAdminClient", "n/a",
+		)
+	}
+	d.credMu.Lock()
+	defer d.credMu.Unlock()
+	if d.adminUsesIAMTS {
+		d.iamTokenSource.set(ts)
+		return nil
+	}
+	opts := append(d.baseAdminOpts[:len(d.baseAdminOpts):len(d.baseAdminOpts)],
+		option.WithTokenSource(d.iamTokenSource))
+	c, err := newAdminClient(context.Background(), d.adminTransport, opts...)
 	if err != nil {
-		_ = tlsConn.Close() // best effort close attempt
-		return nil, err
+		return fmt.Errorf("failed to create AlloyDB Admin API client: %v", err)
 	}
+	d.iamTokenSource.set(ts)
+	d.adminUsesIAMTS = true
 
-	latency := time.Since(startTime).Milliseconds()
-	go func() {
-		n := atomic.AddUint64(i.OpenConns(), 1)
-		trace.RecordOpenConnections(ctx, int64(n), d.dialerID, inst.String())
-		trace.RecordDialLatency(ctx, instance, d.dialerID, latency)
-	}()
+	// Swap the client and drop the instances using the old one. Lock
+	// clientMu before lock, as in instance.
+	d.clientMu.Lock()
+	d.lock.Lock()
+	d.client = c
+	old := make([]connectionInfoCache, 0, len(d.instances))
+	for inst, i := range d.instances {
+		old = append(old, i)
+		delete(d.instances, inst)
+		delete(d.lastUsed, inst)
+		d.stopForcedRefreshLocked(inst)
+	}
+	d.lock.Unlock()
+	d.clientMu.Unlock()
+	// Close the instances without holding the lock, as waiting on in-flight
+	// refreshes may take up to the refresh timeout.
+	for _, i := range old {
+		i.Close()
+	}
+	return nil
+}
 
-	return newInstrumentedConn(tlsConn, func() {
-		n := atomic.AddUint64(i.OpenConns(), ^uint64(0))
-		trace.RecordOpenConnections(context.Background(), int64(n), d.dialerID, inst.String())
-	}), nil
+// ID returns the unique ID of the Dialer, which is a random UUID unless set
+// with WithDialerID. The ID is reported as the alloydb_dialer_id tag of the
+// Dialer's metrics and the /alloydb/dialer_id attribute of its trace spans,
+// so it can be used to correlate the Dialer's connections across logs,
+// metrics, and traces.
+func (d *Dialer) ID() string {
+	return d.dialerID
+}
+
+// IAMAuthN reports whether the Dialer was configured with automatic IAM
+// database authentication (see WithIAMAuthN), taking a WithDialIAMAuthN
+// default dial option into account.
+func (d *Dialer) IAMAuthN() bool {
+	if v := d.defaultDialCfg.iamAuthN; v != nil {
+		return *v
+	}
+	return d.useIAMAuthN
+}
+
+// ApplicationName returns the Postgres application_name configured with
+// WithApplicationName as a default dial option, or "" if there is none.
+// Drivers use it to set the application_name of their connections.
+func (d *Dialer) ApplicationName() string {
+	return d.defaultDialCfg.applicationName
 }
 
 func invalidClientCert(c *tls.Config) bool {
@@ -307,6 +1853,21 @@ func invalidClientCert(c *tls.Config) bool {
 	return time.Now().After(c.Certificates[0].Leaf.NotAfter)
 }
 
+// isVerifyError reports whether err was caused by a failure to verify the
+// server's certificate chain.
+func isVerifyError(err error) bool {
+	var (
+		verifyErr   *tls.CertificateVerificationError
+		authErr     x509.UnknownAuthorityError
+		invalidErr  x509.CertificateInvalidError
+		hostnameErr x509.HostnameError
+	)
+	return errors.As(err, &verifyErr) ||
+		errors.As(err, &authErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &hostnameErr)
+}
+
 // metadataExchange sends metadata about the connection prior to the database
 // protocol taking over. The exchange consists of four steps:
 //
@@ -326,13 +1887,13 @@ func invalidClientCert(c *tls.Config) bool {
 //     metadata exchange has succeeded and the connection is complete.
 //
 // Subsequent interactions with the server use the database protocol.
-func (d *Dialer) metadataExchange(conn net.Conn) error {
+func (d *Dialer) metadataExchange(conn net.Conn, useIAMAuthN bool) error {
 	tok, err := d.iamTokenSource.Token()
 	if err != nil {
 		return err
 	}
 	authType := connectorspb.MetadataExchangeRequest_DB_NATIVE
-	if d.useIAMAuthN {
+	if useIAMAuthN {
 		authType = connectorspb.MetadataExchangeRequest_AUTO_IAM
 	}
 	req := &connectorspb.MetadataExchangeRequest{
@@ -422,11 +1983,197 @@ func (b *buffer) put(buf *[]byte) {
 	b.pool.Put(buf)
 }
 
+// newNetDialer returns the net.Dialer used to connect to instances when no
+// dial func has been configured. Connect timeouts are derived from the Dial
+// context's deadline.
+func newNetDialer(keepAlive time.Duration) *net.Dialer {
+	return &net.Dialer{KeepAlive: keepAlive}
+}
+
+// defaultDialFunc returns a dial func that connects with a net.Dialer using
+// the provided keep-alive period, through a proxy if one is configured in the
+// environment (e.g., with ALL_PROXY).
+func defaultDialFunc(keepAlive time.Duration) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := proxy.FromEnvironmentUsing(newNetDialer(keepAlive))
+		if cd, ok := d.(proxy.ContextDialer); ok {
+			return cd.DialContext(ctx, network, addr)
+		}
+		return d.Dial(network, addr)
+	}
+}
+
+// checkIPVersion returns a ConfigError if addr is not of the wanted IP
+// version. Addresses that are not IP addresses (e.g., DNS names) never
+// match a specific version.
+func checkIPVersion(inst alloydb.InstanceURI, ipType, addr string, want IPVersion) error {
+	if want == AnyIPVersion {
+		return nil
+	}
+	got := AnyIPVersion
+	if ip := net.ParseIP(addr); ip != nil {
+		got = IPv6
+		if ip.To4() != nil {
+			got = IPv4
+		}
+	}
+	if got != want {
+		return errtype.NewConfigError(
+			fmt.Sprintf("instance does not have an %v address of type %q", want, ipType),
+			inst.String(),
+		)
+	}
+	return nil
+}
+
+// httpProxyDialFunc returns a dial func that connects to the address through
+// a tunnel established with an HTTP CONNECT request to the proxy at u. If u
+// has user info, it is sent to the proxy as basic authentication.
+func httpProxyDialFunc(u *url.URL, keepAlive time.Duration) func(context.Context, string, string) (net.Conn, error) {
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := newNetDialer(keepAlive).DialContext(ctx, network, proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "https" {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("TLS handshake with proxy failed: %w", err)
+			}
+			conn = tlsConn
+		}
+		tunnel, err := connectTunnel(ctx, conn, u.User, addr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tunnel, nil
+	}
+}
+
+// connectTunnel sends an HTTP CONNECT request for addr over conn and returns
+// a connection to addr once the proxy has established the tunnel.
+func connectTunnel(ctx context.Context, conn net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT request to proxy: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %v: %v", addr, resp.Status)
+	}
+	// The server side proxy doesn't send anything before the TLS handshake,
+	// but keep anything the proxy sent after its response.
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads are served from r first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// InstanceURI identifies an AlloyDB instance by its project, region, cluster,
+// and instance name. Use ParseInstanceURI to create one.
+type InstanceURI = alloydb.InstanceURI
+
+// ParseInstanceURI parses an instance URI in the format
+// projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>,
+// e.g., to extract the project and region of an InstanceConn for monitoring.
+func ParseInstanceURI(uri string) (InstanceURI, error) {
+	return alloydb.ParseInstURI(uri)
+}
+
+// ValidateInstanceURI reports whether uri is a valid instance URI in the
+// format projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>
+// or in the short format <PROJECT>:<REGION>:<CLUSTER>:<INSTANCE>, returning a
+// ConfigError if it is not. It makes no network calls, so it can be used to
+// validate instance URIs from configuration at startup.
+func ValidateInstanceURI(uri string) error {
+	_, err := alloydb.ParseInstURI(uri)
+	return err
+}
+
+// acquireConn increments the open connection count unless doing so would
+// exceed max, in which case it reports false, and raises the high-water mark
+// maxOpenConns to the new count. A max of zero means there is no limit.
+func acquireConn(openConns, maxOpenConns *uint64, max uint64) bool {
+	var n uint64
+	for {
+		n = atomic.LoadUint64(openConns)
+		if max > 0 && n >= max {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(openConns, n, n+1) {
+			break
+		}
+	}
+	for {
+		m := atomic.LoadUint64(maxOpenConns)
+		if m > n || atomic.CompareAndSwapUint64(maxOpenConns, m, n+1) {
+			return true
+		}
+	}
+}
+
+// InstanceConn is implemented by the connections returned from Dial. Callers
+// may type-assert a net.Conn to InstanceConn to learn which instance the
+// connection is for, e.g., for connection-level logging.
+type InstanceConn interface {
+	net.Conn
+	// InstanceURI returns the canonical URI of the instance the connection
+	// was dialed to, e.g.,
+	// projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance.
+	InstanceURI() string
+	// IPType returns the type of IP address used for the connection, e.g.,
+	// PRIVATE.
+	IPType() string
+}
+
 // newInstrumentedConn initializes an instrumentedConn that on closing will
 // decrement the number of open connects and record the result.
-func newInstrumentedConn(conn net.Conn, closeFunc func()) *instrumentedConn {
+func newInstrumentedConn(conn net.Conn, instance, ipType string, closeFunc func()) *instrumentedConn {
 	return &instrumentedConn{
 		Conn:      conn,
+		instance:  instance,
+		ipType:    ipType,
 		closeFunc: closeFunc,
 	}
 }
@@ -435,52 +2182,331 @@ func newInstrumentedConn(conn net.Conn, closeFunc func()) *instrumentedConn {
 // is closed.
 type instrumentedConn struct {
 	net.Conn
+	instance  string
+	ipType    string
 	closeFunc func()
+	closeOnce sync.Once
+	// expiry, if set, closes the connection once its client certificate
+	// expires.
+	expiry *time.Timer
+}
+
+// InstanceURI returns the URI of the instance the connection is for.
+func (i *instrumentedConn) InstanceURI() string {
+	return i.instance
+}
+
+// IPType returns the type of IP address used for the connection.
+func (i *instrumentedConn) IPType() string {
+	return i.ipType
+}
+
+// closeAt closes the connection at t. It must be called before the connection
+// is returned to the caller.
+func (i *instrumentedConn) closeAt(t time.Time) {
+	i.expiry = time.AfterFunc(time.Until(t), func() { _ = i.close() })
 }
 
 // Close delegates to the underlying net.Conn interface and reports the close
 // to the provided closeFunc only when Close returns no error.
 func (i *instrumentedConn) Close() error {
+	if i.expiry != nil {
+		i.expiry.Stop()
+	}
+	return i.close()
+}
+
+func (i *instrumentedConn) close() error {
 	err := i.Conn.Close()
 	if err != nil {
 		return err
 	}
-	go i.closeFunc()
+	i.closeOnce.Do(func() { go i.closeFunc() })
 	return nil
 }
 
 // Close closes the Dialer; it prevents the Dialer from refreshing the information
-// needed to connect. Additional dial operations may succeed until the information
+// needed to connect. Close blocks until any in-flight refresh operations have
+// returned. Additional dial operations may succeed until the information
 // expires.
 func (d *Dialer) Close() error {
+	if d.stopSweeper != nil {
+		d.stopSweeper()
+		<-d.sweeperDone
+	}
 	d.lock.Lock()
-	defer d.lock.Unlock()
+	instances := make([]connectionInfoCache, 0, len(d.instances))
 	for _, i := range d.instances {
+		instances = append(instances, i)
+	}
+	for _, t := range d.forcedRefreshes {
+		t.Stop()
+	}
+	d.forcedRefreshes = nil
+	d.lock.Unlock()
+	// Close the instances without holding the lock, as waiting on in-flight
+	// refreshes may take up to the refresh timeout.
+	for _, i := range instances {
 		i.Close()
 	}
 	return nil
 }
 
-func (d *Dialer) instance(instance alloydb.InstanceURI) (connectionInfoCache, error) {
+// DrainAndClose gracefully shuts down the Dialer. New calls to Dial fail
+// immediately with a DialError wrapping ErrDialerClosing, while
+// DrainAndClose waits for calls to Dial in progress to return and for all
+// open connections to be closed. It then closes the Dialer. If ctx is done
+// before the connections are closed, DrainAndClose closes the Dialer without
+// waiting further and returns the context's error. Open connections are never
+// closed by DrainAndClose.
+func (d *Dialer) DrainAndClose(ctx context.Context) error {
+	atomic.StoreInt32(&d.closing, 1)
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+	for d.active() > 0 {
+		select {
+		case <-ctx.Done():
+			d.Close()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return d.Close()
+}
+
+// drainPollInterval is how often DrainAndClose checks for open connections.
+const drainPollInterval = 50 * time.Millisecond
+
+// active returns the number of open connections across all instances, plus
+// the number of calls to Dial in progress.
+func (d *Dialer) active() uint64 {
+	n := uint64(atomic.LoadInt64(&d.dialing))
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, i := range d.instances {
+		n += atomic.LoadUint64(i.OpenConns())
+	}
+	return n
+}
+
+// removeIfNotFound removes the provided instance from the cache if err reports
+// that the instance does not exist, as refreshing it would only fail again.
+func (d *Dialer) removeIfNotFound(instance alloydb.InstanceURI, i connectionInfoCache, err error) {
+	var nfErr *errtype.NotFoundError
+	if errors.As(err, &nfErr) {
+		d.removeInstance(instance, i)
+	}
+}
+
+// removeInstance stops the background refresh of the provided instance and
+// removes it from the cache.
+func (d *Dialer) removeInstance(instance alloydb.InstanceURI, i connectionInfoCache) {
+	d.lock.Lock()
+	// Another caller may have replaced the instance in the meantime.
+	if d.instances[instance] == i {
+		delete(d.instances, instance)
+		delete(d.lastUsed, instance)
+		d.stopForcedRefreshLocked(instance)
+	}
+	d.lock.Unlock()
+	// Stop all background refreshes
+	i.Close()
+}
+
+const (
+	// clientBackoffInitial and clientBackoffMax bound the delay between
+	// attempts to lazily create the Admin API client.
+	clientBackoffInitial = time.Second
+	clientBackoffMax     = time.Minute
+)
+
+// adminClient creates the Admin API client if it is created lazily and
+// doesn't exist yet. After a failed attempt, it reports the same error
+// without trying again until the backoff delay has passed.
+func (d *Dialer) adminClient() error {
+	if d.newClient == nil {
+		return nil
+	}
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+	if d.client != nil {
+		return nil
+	}
+	now := d.now()
+	if now.Before(d.nextClientCreate) {
+		return d.clientErr
+	}
+	c, err := d.newClient()
+	if err != nil {
+		switch {
+		case d.clientBackoff == 0:
+			d.clientBackoff = clientBackoffInitial
+		case d.clientBackoff < clientBackoffMax:
+			d.clientBackoff *= 2
+			if d.clientBackoff > clientBackoffMax {
+				d.clientBackoff = clientBackoffMax
+			}
+		}
+		d.nextClientCreate = now.Add(d.clientBackoff)
+		d.clientErr = fmt.Errorf("failed to create AlloyDB Admin API client: %w", err)
+		d.logger.Debugf("%v, next attempt at %v", d.clientErr, d.nextClientCreate.Format(time.RFC3339))
+		return d.clientErr
+	}
+	d.client = c
+	d.clientErr = nil
+	return nil
+}
+
+// instance returns the cached connection info of the instance, creating it if
+// necessary. A new instance uses refreshTimeout if it is positive, and the
+// Dialer's refresh timeout otherwise.
+func (d *Dialer) instance(instance alloydb.InstanceURI, refreshTimeout time.Duration) (connectionInfoCache, error) {
+	if err := d.adminClient(); err != nil {
+		return nil, err
+	}
+	if d.maxInstances > 0 || d.idleTimeout > 0 {
+		return d.instanceLRU(instance, refreshTimeout), nil
+	}
 	// Check instance cache
 	d.lock.RLock()
 	i, ok := d.instances[instance]
 	d.lock.RUnlock()
-	if !ok {
-		d.lock.Lock()
-		// Recheck to ensure instance wasn't created between locks
-		i, ok = d.instances[instance]
-		if !ok {
-			// Create a new instance
-			var err error
-			i = alloydb.NewInstance(instance, d.client, d.key, d.refreshTimeout, d.dialerID)
-			if err != nil {
-				d.lock.Unlock()
-				return nil, err
+	if ok {
+		d.logger.Debugf("[%v] Connection info found in cache", instance.String())
+		return i, nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	// Recheck to ensure instance wasn't created between locks
+	i, ok = d.instances[instance]
+	if ok {
+		d.logger.Debugf("[%v] Connection info found in cache", instance.String())
+		return i, nil
+	}
+	i = d.newCache(instance, refreshTimeout)
+	d.instances[instance] = i
+	return i, nil
+}
+
+// instanceUse records when an instance was last dialed.
+type instanceUse struct {
+	// seq orders uses across instances.
+	seq uint64
+	at  time.Time
+}
+
+// instanceLRU is like instance, but also records the use of the instance and
+// evicts the least recently used idle instances once the cache holds more
+// than maxInstances.
+func (d *Dialer) instanceLRU(instance alloydb.InstanceURI, refreshTimeout time.Duration) connectionInfoCache {
+	d.lock.Lock()
+	d.useSeq++
+	d.lastUsed[instance] = instanceUse{seq: d.useSeq, at: d.now()}
+	i, ok := d.instances[instance]
+	if ok {
+		d.lock.Unlock()
+		d.logger.Debugf("[%v] Connection info found in cache", instance.String())
+		return i
+	}
+	i = d.newCache(instance, refreshTimeout)
+	d.instances[instance] = i
+	var evicted []connectionInfoCache
+	if d.maxInstances > 0 {
+		evicted = d.evictIdle(instance)
+	}
+	d.lock.Unlock()
+	// Close the evicted instances without holding the lock, as waiting on
+	// in-flight refreshes may take up to the refresh timeout.
+	for _, e := range evicted {
+		e.Close()
+	}
+	return i
+}
+
+// evictIdle removes the least recently used instances without open
+// connections, other than keep, until the cache holds at most maxInstances,
+// and returns the removed instances. The caller must hold lock.
+func (d *Dialer) evictIdle(keep alloydb.InstanceURI) []connectionInfoCache {
+	var evicted []connectionInfoCache
+	for len(d.instances) > d.maxInstances {
+		var (
+			oldest alloydb.InstanceURI
+			seq    uint64
+			found  bool
+		)
+		for inst, i := range d.instances {
+			if inst == keep || atomic.LoadUint64(i.OpenConns()) > 0 {
+				continue
+			}
+			if s := d.lastUsed[inst].seq; !found || s < seq {
+				oldest, seq, found = inst, s, true
 			}
-			d.instances[instance] = i
 		}
-		d.lock.Unlock()
+		if !found {
+			break
+		}
+		d.logger.Debugf("[%v] Evicting idle instance from cache", oldest.String())
+		evicted = append(evicted, d.instances[oldest])
+		delete(d.instances, oldest)
+		delete(d.lastUsed, oldest)
+		d.stopForcedRefreshLocked(oldest)
 	}
-	return i, nil
+	return evicted
+}
+
+// sweepIdle periodically closes instances that have been idle for longer than
+// idleTimeout until ctx is done.
+func (d *Dialer) sweepIdle(ctx context.Context) {
+	defer close(d.sweeperDone)
+	// Sweeping at half the timeout closes an idle instance at most 1.5 times
+	// the timeout after it was last dialed.
+	t := time.NewTicker(d.idleTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			d.reapIdle()
+		}
+	}
+}
+
+// reapIdle closes and removes the instances without open connections that
+// have not been dialed within idleTimeout.
+func (d *Dialer) reapIdle() {
+	now := d.now()
+	var idle []connectionInfoCache
+	d.lock.Lock()
+	for inst, i := range d.instances {
+		if now.Sub(d.lastUsed[inst].at) <= d.idleTimeout ||
+			atomic.LoadUint64(i.OpenConns()) > 0 {
+			continue
+		}
+		d.logger.Debugf("[%v] Closing idle instance", inst.String())
+		idle = append(idle, i)
+		delete(d.instances, inst)
+		delete(d.lastUsed, inst)
+		d.stopForcedRefreshLocked(inst)
+	}
+	d.lock.Unlock()
+	for _, i := range idle {
+		i.Close()
+	}
+}
+
+// newCache creates the connection info cache for the instance. If
+// refreshTimeout is not positive, the Dialer's refresh timeout is used.
+func (d *Dialer) newCache(instance alloydb.InstanceURI, refreshTimeout time.Duration) connectionInfoCache {
+	d.logger.Debugf("[%v] Connection info not found in cache, adding it", instance.String())
+	if refreshTimeout <= 0 {
+		refreshTimeout = d.refreshTimeout
+	}
+	if d.staticTLS != nil {
+		return alloydb.NewStaticConnectionInfo(instance, d.staticAddr, d.staticTLS)
+	}
+	if d.lazyRefresh {
+		return alloydb.NewLazyRefreshCache(instance, d.logger, d.client, d.key, refreshTimeout, d.dialerID, d.refreshOpts...)
+	}
+	return alloydb.NewInstance(instance, d.logger, d.client, d.key, refreshTimeout, d.dialerID, d.refreshOpts...)
 }
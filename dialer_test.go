@@ -15,24 +15,42 @@
 package alloydbconn
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
-	alloydbadmin "cloud.google.com/go/alloydb/apiv1beta"
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
 	"cloud.google.com/go/alloydbconn/errtype"
 	"cloud.google.com/go/alloydbconn/internal/alloydb"
 	"cloud.google.com/go/alloydbconn/internal/mock"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
@@ -42,6 +60,35 @@ func (stubTokenSource) Token() (*oauth2.Token, error) {
 	return &oauth2.Token{}, nil
 }
 
+// newTestDialer returns a Dialer configured with opts, whose AlloyDB Admin API
+// requests are served by the provided mocks, and starts the server side proxy
+// of inst. The Dialer uses stubTokenSource unless opts configure credentials. When the test completes, the Dialer is closed, the proxy stopped,
+// and the test fails if any of the mocks wasn't called.
+func newTestDialer(t *testing.T, inst mock.FakeAlloyDBInstance, mocks []*mock.Request, opts ...Option) *Dialer {
+	t.Helper()
+	mc, url, cleanup := mock.HTTPClient(mocks...)
+	t.Cleanup(func() {
+		if err := cleanup(); err != nil {
+			t.Errorf("%v", err)
+		}
+	})
+	t.Cleanup(mock.StartServerProxy(t, inst))
+
+	opts = append([]Option{WithHTTPClient(mc), WithAdminAPIEndpoint(url)}, opts...)
+	// Use stubTokenSource, unless opts configure credentials.
+	opts = append(opts, func(cfg *dialerConfig) {
+		if cfg.tokenSource == nil {
+			cfg.tokenSource = stubTokenSource{}
+		}
+	})
+	d, err := NewDialer(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	return d
+}
+
 func TestDialerCanConnectToInstance(t *testing.T) {
 	ctx := context.Background()
 	inst := mock.NewFakeInstance(
@@ -161,6 +208,125 @@ func TestDialWithUnavailableServerErrors(t *testing.T) {
 	}
 }
 
+func TestDialWithUntrustedServerCertErrors(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithUntrustedServerCert(),
+	)
+	// Don't use the cleanup function. A failed handshake forces a refresh
+	// that may or may not complete before the test ends.
+	mc, url, _ := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer stop()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	d, err := NewDialer(ctx, WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	d.client = c
+
+	_, err = d.Dial(ctx, "/projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	var wantErr *errtype.TLSError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when server cert is untrusted, want = %T, got = %v", wantErr, err)
+	}
+	var authErr x509.UnknownAuthorityError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("want error to wrap %T, got = %v", authErr, err)
+	}
+}
+
+func TestDialerWithRootCAs(t *testing.T) {
+	ctx := context.Background()
+	// The server side proxy presents a certificate signed by a CA other
+	// than the instance's CA.
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithUntrustedServerCert(),
+	)
+	pool := x509.NewCertPool()
+	pool.AddCert(inst.ServerCert())
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithRootCAs(pool))
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerWithRootCAsErrors(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithRootCAs(nil),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when root CA pool is nil, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialerWithTLSServerNameOverride(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithServerName("my-instance.example.com"),
+	)
+	// Don't use the cleanup function. A failed handshake forces a refresh
+	// that may or may not complete before the test ends.
+	mc, url, _ := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer stop()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithTLSServerNameOverride("other.example.com"),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	d.client = c
+
+	// The server's certificate is verified against the override, which the
+	// certificate was not issued for.
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	_, err = d.Dial(ctx, instURI)
+	var hostErr x509.HostnameError
+	if !errors.As(err, &hostErr) {
+		t.Fatalf("want = %T, got = %v", hostErr, err)
+	}
+	if hostErr.Host != "other.example.com" {
+		t.Fatalf("want certificate verified for %q, got = %q", "other.example.com", hostErr.Host)
+	}
+
+	// A one-off override takes precedence.
+	conn, err := d.Dial(ctx, instURI, WithOneOffTLSServerNameOverride("my-instance.example.com"))
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
 func TestDialerWithCustomDialFunc(t *testing.T) {
 	ctx := context.Background()
 	inst := mock.NewFakeInstance(
@@ -211,11 +377,41 @@ func TestDialerUserAgent(t *testing.T) {
 	}
 }
 
+func TestDialerWithUserAgent(t *testing.T) {
+	d, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithUserAgent("my-orm/1.2.3"),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	want := userAgent + " my-orm/1.2.3"
+	if d.userAgent != want {
+		t.Fatalf("want user agent = %q, got = %q", want, d.userAgent)
+	}
+}
+
+func TestDialerWithUserAgentContainingNewlineFails(t *testing.T) {
+	for _, ua := range []string{"my-orm\n1.2.3", "my-orm\r\n"} {
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithUserAgent(ua),
+		)
+		var cfgErr *errtype.ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("WithUserAgent(%q): want ConfigError, got = %v", ua, err)
+		}
+	}
+}
+
 func TestDialerRemovesInvalidInstancesFromCache(t *testing.T) {
 	// When a dialer attempts to retrieve connection info for a
 	// non-existent instance, it should delete the instance from
 	// the cache and ensure no background refresh happens (which would be
-	// wasted cycles).
+	// wasted cycles). The Admin API reports a missing instance with a
+	// NotFoundError.
 	d, err := NewDialer(context.Background(),
 		WithTokenSource(stubTokenSource{}),
 		WithRefreshTimeout(time.Second),
@@ -243,7 +439,7 @@ func TestDialerRemovesInvalidInstancesFromCache(t *testing.T) {
 			tls *tls.Config
 			err error
 		}{{
-			err: errors.New("connect info failed"),
+			err: errtype.NewNotFoundError("instance does not exist", badInst.String(), nil),
 		}},
 	}
 	d.instances[badInst] = spy
@@ -267,6 +463,87 @@ func TestDialerRemovesInvalidInstancesFromCache(t *testing.T) {
 	}
 }
 
+func TestDialerRemovesNotFoundInstancesFromCache(t *testing.T) {
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	tcs := []struct {
+		desc string
+		req  *mock.Request
+		call func(*Dialer, string) error
+	}{
+		{
+			desc: "EngineVersion",
+			req:  mock.ClusterGetError(inst, http.StatusNotFound, 1),
+			call: func(d *Dialer, instance string) error {
+				_, err := d.EngineVersion(context.Background(), instance)
+				return err
+			},
+		},
+		{
+			desc: "CertificateInfo",
+			req:  mock.InstanceGetError(inst, http.StatusNotFound, 1),
+			call: func(d *Dialer, instance string) error {
+				_, err := d.CertificateInfo(context.Background(), instance)
+				return err
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := newTestDialer(t, inst, []*mock.Request{tc.req})
+
+			instance := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+			err := tc.call(d, instance)
+			var nfErr *errtype.NotFoundError
+			if !errors.As(err, &nfErr) {
+				t.Fatalf("want = %T, got = %v", nfErr, err)
+			}
+			if got := d.CachedInstances(); len(got) != 0 {
+				t.Fatalf("want the instance removed from the cache, got = %v", got)
+			}
+		})
+	}
+}
+
+func TestDialerKeepsInstancesWithTransientErrorsCached(t *testing.T) {
+	d, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	instURI := "/projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	inst, _ := alloydb.ParseInstURI(instURI)
+	spy := &spyConnectionInfoCache{
+		connectInfoCalls: []struct {
+			tls *tls.Config
+			err error
+		}{{
+			err: errtype.NewRefreshError("failed to get instance metadata", inst.String(),
+				&googleapi.Error{Code: http.StatusServiceUnavailable}),
+		}},
+	}
+	d.instances[inst] = spy
+
+	_, err = d.Dial(context.Background(), instURI)
+	var refreshErr *errtype.RefreshError
+	if !errors.As(err, &refreshErr) {
+		t.Fatalf("want = %T, got = %v", refreshErr, err)
+	}
+	if spy.CloseWasCalled() {
+		t.Fatal("want instance with a transient error to keep refreshing")
+	}
+	d.lock.RLock()
+	_, ok := d.instances[inst]
+	d.lock.RUnlock()
+	if !ok {
+		t.Fatal("want instance with a transient error to remain cached")
+	}
+}
+
 func TestDialRefreshesExpiredCertificates(t *testing.T) {
 	d, err := NewDialer(
 		context.Background(),
@@ -276,9 +553,9 @@ func TestDialRefreshesExpiredCertificates(t *testing.T) {
 		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
 	}
 
-	sentinel := errors.New("connect info failed")
 	inst := "/projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
 	cn, _ := alloydb.ParseInstURI(inst)
+	sentinel := errtype.NewNotFoundError("instance does not exist", cn.String(), nil)
 	spy := &spyConnectionInfoCache{
 		connectInfoCalls: []struct {
 			tls *tls.Config
@@ -337,11 +614,13 @@ type spyConnectionInfoCache struct {
 	}
 	closeWasCalled        bool
 	forceRefreshWasCalled bool
+	isValid               bool
+	openConns             uint64
 	// embed interface to avoid having to implement irrelevant methods
 	connectionInfoCache
 }
 
-func (s *spyConnectionInfoCache) ConnectInfo(_ context.Context) (string, *tls.Config, error) {
+func (s *spyConnectionInfoCache) ConnectInfo(_ context.Context, _ string) (string, *tls.Config, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	res := s.connectInfoCalls[s.connectInfoIndex]
@@ -349,12 +628,22 @@ func (s *spyConnectionInfoCache) ConnectInfo(_ context.Context) (string, *tls.Co
 	return "unused", res.tls, res.err
 }
 
+func (s *spyConnectionInfoCache) IsValid() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isValid
+}
+
 func (s *spyConnectionInfoCache) ForceRefresh() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.forceRefreshWasCalled = true
 }
 
+func (s *spyConnectionInfoCache) OpenConns() *uint64 {
+	return &s.openConns
+}
+
 func (s *spyConnectionInfoCache) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -422,3 +711,4592 @@ func TestDialerSupportsOneOffDialFunction(t *testing.T) {
 		t.Fatal("one-off dial func was not called")
 	}
 }
+
+func TestDialerWithLazyRefresh(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithLazyRefresh())
+
+	// Dial twice to verify the cached connection info is reused.
+	for i := 0; i < 2; i++ {
+		conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+		if err != nil {
+			t.Fatalf("expected Dial to succeed, but got error: %v", err)
+		}
+		conn.Close()
+	}
+
+	inURI, _ := alloydb.ParseInstURI("projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	d.lock.RLock()
+	i := d.instances[inURI]
+	d.lock.RUnlock()
+	if _, ok := i.(*alloydb.LazyRefreshCache); !ok {
+		t.Fatalf("want = %T, got = %T", &alloydb.LazyRefreshCache{}, i)
+	}
+}
+
+func TestDialerEngineVersion(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithDatabaseVersion("POSTGRES_14"),
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+		mock.ClusterGetSuccess(inst, 1),
+	})
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	// Call twice to verify the version is cached.
+	for i := 0; i < 2; i++ {
+		got, err := d.EngineVersion(ctx, instURI)
+		if err != nil {
+			t.Fatalf("expected EngineVersion to succeed, but got error: %v", err)
+		}
+		if want := "POSTGRES_14"; got != want {
+			t.Fatalf("EngineVersion mismatch, want = %v, got = %v", want, got)
+		}
+	}
+
+	// Dial should use the cached connection info.
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerEngineVersionErrors(t *testing.T) {
+	ctx := context.Background()
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	d, err := NewDialer(ctx, WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	d.client = c
+	defer d.Close()
+
+	_, err = d.EngineVersion(ctx, "bad-instance-name")
+	var wantErr1 *errtype.ConfigError
+	if !errors.As(err, &wantErr1) {
+		t.Fatalf("when instance name is invalid, want = %T, got = %v", wantErr1, err)
+	}
+
+	_, err = d.EngineVersion(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	var wantErr2 *errtype.RefreshError
+	if !errors.As(err, &wantErr2) {
+		t.Fatalf("when API call fails, want = %T, got = %v", wantErr2, err)
+	}
+}
+
+type spyTokenSource struct {
+	mu    sync.Mutex
+	calls int
+	token string
+}
+
+func (s *spyTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return &oauth2.Token{AccessToken: s.token}, nil
+}
+
+func (s *spyTokenSource) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestDialerWithIAMAuthN(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+
+	tcs := []struct {
+		desc    string
+		token   string
+		wantErr bool
+	}{
+		{desc: "with a valid token", token: "my-token"},
+		{desc: "with an empty token", token: "", wantErr: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := newTestDialer(t, inst, []*mock.Request{
+				mock.InstanceGetSuccess(inst, 1),
+				mock.CreateEphemeralSuccess(inst, 1),
+			}, WithTokenSource(&spyTokenSource{token: tc.token}), WithIAMAuthN())
+			if !d.IAMAuthN() {
+				t.Fatal("want IAMAuthN = true, got = false")
+			}
+
+			conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+			if tc.wantErr {
+				if err == nil {
+					conn.Close()
+					t.Fatal("expected Dial to fail, but got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected Dial to succeed, but got error: %v", err)
+			}
+			conn.Close()
+			got := inst.OAuth2Tokens()
+			if len(got) == 0 || got[len(got)-1] != tc.token {
+				t.Fatalf("want token %q sent in the metadata exchange, got = %v", tc.token, got)
+			}
+		})
+	}
+}
+
+func TestDialerWithDialIAMAuthN(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+
+	// The fake server rejects IAM authentication without a token, so an
+	// empty token shows whether IAM authentication was requested.
+	tcs := []struct {
+		desc    string
+		opts    []Option
+		dialOpt DialOption
+		wantErr bool
+	}{
+		{
+			desc:    "enabled for the call",
+			dialOpt: WithDialIAMAuthN(true),
+			wantErr: true,
+		},
+		{
+			desc:    "disabled for the call",
+			opts:    []Option{WithIAMAuthN()},
+			dialOpt: WithDialIAMAuthN(false),
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := newTestDialer(t, inst, []*mock.Request{
+				mock.InstanceGetSuccess(inst, 1),
+				mock.CreateEphemeralSuccess(inst, 1),
+			}, append([]Option{WithTokenSource(&spyTokenSource{})}, tc.opts...)...)
+
+			conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance", tc.dialOpt)
+			if tc.wantErr {
+				if err == nil {
+					conn.Close()
+					t.Fatal("expected Dial to fail, but got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected Dial to succeed, but got error: %v", err)
+			}
+			conn.Close()
+		})
+	}
+}
+
+func TestDialerWithUnavailableIPType(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	// The instance only has a private IP.
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	_, err := d.Dial(ctx, instURI, WithPublicIP())
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when public IP is unavailable, want = %T, got = %v", wantErr, err)
+	}
+
+	// The cached connection info should be reused for a private IP dial.
+	conn, err := d.Dial(ctx, instURI, WithPrivateIP())
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+type spyLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *spyLogger) Debugf(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, fmt.Sprintf(format, args...))
+}
+
+// Contains reports whether any logged line contains the provided string.
+func (s *spyLogger) Contains(want string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.lines {
+		if strings.Contains(l, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count reports the number of logged lines that contain the provided string.
+func (s *spyLogger) Count(want string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, l := range s.lines {
+		if strings.Contains(l, want) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDialerWithDebugLogger(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	spy := &spyLogger{}
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithDebugLogger(spy))
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	if want := "Connection info not found in cache"; !spy.Contains(want) {
+		t.Fatalf("want log line containing %q", want)
+	}
+
+	conn, err = d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	if want := "Connection info found in cache"; !spy.Contains(want) {
+		t.Fatalf("want log line containing %q", want)
+	}
+}
+
+func TestDialerWarmup(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+
+	// All mocked API calls have been used, so any further API calls from Dial
+	// would fail.
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerWarmupErrors(t *testing.T) {
+	ctx := context.Background()
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	d, err := NewDialer(ctx, WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	d.client = c
+	defer d.Close()
+
+	err = d.Warmup(ctx, "bad-instance-name")
+	var wantErr1 *errtype.ConfigError
+	if !errors.As(err, &wantErr1) {
+		t.Fatalf("when instance name is invalid, want = %T, got = %v", wantErr1, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = d.Warmup(cctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("when context is canceled, want = %T, got = %v", context.Canceled, err)
+	}
+
+	err = d.Warmup(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	var wantErr2 *errtype.RefreshError
+	if !errors.As(err, &wantErr2) {
+		t.Fatalf("when API call fails, want = %T, got = %v", wantErr2, err)
+	}
+}
+
+func TestDialerWithInstanceMetadataTTLErrors(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithInstanceMetadataTTL(-time.Second),
+	)
+	var cfgErr *errtype.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("when instance metadata TTL is negative, want = %T, got = %v", cfgErr, err)
+	}
+}
+
+func TestDialerReturnsInstanceConn(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	ic, ok := conn.(InstanceConn)
+	if !ok {
+		t.Fatalf("want conn to implement InstanceConn, got = %T", conn)
+	}
+	if got := ic.InstanceURI(); got != instURI {
+		t.Fatalf("InstanceURI mismatch, want = %v, got = %v", instURI, got)
+	}
+	if got, want := ic.IPType(), alloydb.PrivateIP; got != want {
+		t.Fatalf("IPType mismatch, want = %v, got = %v", want, got)
+	}
+
+	parsed, _ := alloydb.ParseInstURI(instURI)
+	d.lock.RLock()
+	i := d.instances[parsed]
+	d.lock.RUnlock()
+	waitForOpenConns(t, i, 1)
+	if err := conn.Close(); err != nil {
+		t.Fatalf("want no error on Close, got = %v", err)
+	}
+	waitForOpenConns(t, i, 0)
+}
+
+// waitForOpenConns waits for the open connection count of i to reach want.
+// The count is updated asynchronously after Dial and Close.
+func waitForOpenConns(t *testing.T, i connectionInfoCache, want uint64) {
+	t.Helper()
+	for n := 0; n < 100; n++ {
+		if atomic.LoadUint64(i.OpenConns()) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("open connections mismatch, want = %v, got = %v",
+		want, atomic.LoadUint64(i.OpenConns()))
+}
+
+func TestDialerWithRefreshBufferErrors(t *testing.T) {
+	tcs := []struct {
+		desc   string
+		buffer time.Duration
+	}{
+		{desc: "when the buffer is zero", buffer: 0},
+		{desc: "when the buffer is negative", buffer: -time.Minute},
+		{desc: "when the buffer equals the cert lifetime", buffer: time.Hour},
+		{desc: "when the buffer exceeds the cert lifetime", buffer: 2 * time.Hour},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(),
+				WithTokenSource(stubTokenSource{}),
+				WithRefreshBuffer(tc.buffer),
+			)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDialerWithAdminAPIEndpoint(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+// writeFakeCredentialsFile writes a service account key file to a temporary
+// directory whose token URI points to a local server that issues wantToken.
+func writeFakeCredentialsFile(t *testing.T, wantToken string) string {
+	t.Helper()
+	path, _ := writeRecordingCredentialsFile(t, wantToken)
+	return path
+}
+
+// writeRecordingCredentialsFile is like writeFakeCredentialsFile, but also
+// returns a function that reports the OAuth2 scopes requested by the most
+// recent token request.
+func writeRecordingCredentialsFile(t *testing.T, wantToken string) (string, func() string) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		scope string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The token request carries the scopes in the claims of a signed
+		// JWT.
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) == 3 {
+			var claims struct {
+				Scope string `json:"scope"`
+			}
+			if b, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil &&
+				json.Unmarshal(b, &claims) == nil {
+				mu.Lock()
+				scope = claims.Scope
+				mu.Unlock()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","expires_in":3600}`, wantToken)
+	}))
+	t.Cleanup(ts.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal RSA key: %v", err)
+	}
+	b, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "my-project",
+		"private_key_id": "some-key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "test-sa@my-project.iam.gserviceaccount.com",
+		"token_uri":      ts.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal credentials: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("failed to write credentials file: %v", err)
+	}
+	return path, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return scope
+	}
+}
+
+func TestDialerWithCredentialsFile(t *testing.T) {
+	path := writeFakeCredentialsFile(t, "file-token")
+	d, err := NewDialer(context.Background(), WithCredentialsFile(path))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	tok, err := d.iamTokenSource.Token()
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+	if got, want := tok.AccessToken, "file-token"; got != want {
+		t.Fatalf("token mismatch, want = %v, got = %v", want, got)
+	}
+}
+
+func TestDialerWithMultipleCredentialSourcesFails(t *testing.T) {
+	path := writeFakeCredentialsFile(t, "file-token")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read credentials file: %v", err)
+	}
+	tcs := []struct {
+		desc string
+		opts []Option
+	}{
+		{
+			desc: "file and token source",
+			opts: []Option{WithCredentialsFile(path), WithTokenSource(stubTokenSource{})},
+		},
+		{
+			desc: "token source and JSON",
+			opts: []Option{WithTokenSource(stubTokenSource{}), WithCredentialsJSON(b)},
+		},
+		{
+			desc: "file and JSON",
+			opts: []Option{WithCredentialsFile(path), WithCredentialsJSON(b)},
+		},
+		{
+			desc: "token source and default credentials",
+			opts: []Option{WithTokenSource(stubTokenSource{}), WithDefaultCredentials()},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(), tc.opts...)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDialerWithMaxConnectionsPerInstance(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	const max = 2
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithMaxConnectionsPerInstance(max))
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	// Warm up the cache so all dials race for connection slots only.
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+
+	const attempts = 5
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns []net.Conn
+		errs  []error
+	)
+	for n := 0; n < attempts; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := d.Dial(ctx, instURI)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			conns = append(conns, conn)
+		}()
+	}
+	wg.Wait()
+
+	if got := len(conns); got != max {
+		t.Fatalf("successful dials mismatch, want = %v, got = %v", max, got)
+	}
+	for _, err := range errs {
+		var dialErr *errtype.DialError
+		if !errors.As(err, &dialErr) || !errors.Is(err, ErrMaxConnections) {
+			t.Fatalf("want DialError wrapping ErrMaxConnections, got = %v", err)
+		}
+	}
+
+	// Closing a connection frees a slot for another dial.
+	conns[0].Close()
+	parsed, _ := alloydb.ParseInstURI(instURI)
+	d.lock.RLock()
+	i := d.instances[parsed]
+	d.lock.RUnlock()
+	waitForOpenConns(t, i, max-1)
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed after Close, but got error: %v", err)
+	}
+	conn.Close()
+	conns[1].Close()
+}
+
+// clientCertPublicKey returns the public key of the client certificate the
+// dialer uses for the provided instance.
+func clientCertPublicKey(t *testing.T, d *Dialer, instance string) *rsa.PublicKey {
+	t.Helper()
+	parsed, err := alloydb.ParseInstURI(instance)
+	if err != nil {
+		t.Fatalf("failed to parse instance URI: %v", err)
+	}
+	d.lock.RLock()
+	i := d.instances[parsed]
+	d.lock.RUnlock()
+	_, tlsCfg, err := i.ConnectInfo(context.Background(), alloydb.PrivateIP)
+	if err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	cert, err := x509.ParseCertificate(tlsCfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse client certificate: %v", err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("want RSA public key, got = %T", cert.PublicKey)
+	}
+	return pub
+}
+
+func TestDialerWithRSAKeyOptions(t *testing.T) {
+	suppliedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	tcs := []struct {
+		desc string
+		opt  Option
+		// check verifies the client certificate's public key.
+		check func(t *testing.T, d *Dialer, pub *rsa.PublicKey)
+	}{
+		{
+			desc: "with a supplied key",
+			opt:  WithRSAKey(suppliedKey),
+			check: func(t *testing.T, d *Dialer, pub *rsa.PublicKey) {
+				if d.key != suppliedKey {
+					t.Fatal("want the supplied key to be used without generating one")
+				}
+				if !pub.Equal(&suppliedKey.PublicKey) {
+					t.Fatal("want client certificate for the supplied key")
+				}
+			},
+		},
+		{
+			desc: "with a custom key size",
+			opt:  WithRSAKeySize(3072),
+			check: func(t *testing.T, _ *Dialer, pub *rsa.PublicKey) {
+				if got, want := pub.N.BitLen(), 3072; got != want {
+					t.Fatalf("key size mismatch, want = %v, got = %v", want, got)
+				}
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			inst := mock.NewFakeInstance(
+				"my-project", "my-region", "my-cluster", "my-instance",
+			)
+			mc, url, cleanup := mock.HTTPClient(
+				mock.InstanceGetSuccess(inst, 1),
+				mock.CreateEphemeralSuccess(inst, 1),
+			)
+			defer func() {
+				if err := cleanup(); err != nil {
+					t.Fatalf("%v", err)
+				}
+			}()
+			d, err := NewDialer(ctx,
+				WithTokenSource(stubTokenSource{}),
+				WithHTTPClient(mc),
+				WithAdminAPIEndpoint(url),
+				tc.opt,
+			)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+
+			instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+			if err := d.Warmup(ctx, instURI); err != nil {
+				t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+			}
+			tc.check(t, d, clientCertPublicKey(t, d, instURI))
+		})
+	}
+}
+
+func TestDialerWithWeakRSAKeyFails(t *testing.T) {
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	tcs := []struct {
+		desc string
+		opt  Option
+	}{
+		{desc: "with a supplied weak key", opt: WithRSAKey(weakKey)},
+		{desc: "with a weak key size", opt: WithRSAKeySize(1024)},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(), WithTokenSource(stubTokenSource{}), tc.opt)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+// authCheckingTransport rejects requests that don't carry the wanted bearer
+// token with a 401 and forwards all others to base.
+type authCheckingTransport struct {
+	wantToken string
+	base      http.RoundTripper
+}
+
+func (a authCheckingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "Bearer "+a.wantToken {
+		return &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body: io.NopCloser(strings.NewReader(
+				`{"error":{"code":401,"message":"invalid credentials","status":"UNAUTHENTICATED"}}`,
+			)),
+			Request: req,
+		}, nil
+	}
+	return a.base.RoundTrip(req)
+}
+
+func TestDialerWithTokenSourceRefresher(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// Only the refresh made after the token source is refreshed reaches
+	// the mock API.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	var refreshes int32
+	d, err := NewDialer(ctx,
+		WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "stale"})),
+		WithTokenSourceRefresher(func(context.Context) (oauth2.TokenSource, error) {
+			atomic.AddInt32(&refreshes, 1)
+			return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "fresh"}), nil
+		}),
+		// Lazy refresh avoids background refreshes racing the test.
+		WithLazyRefresh(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	// Authenticate the Admin API client with the dialer's token source, as
+	// NewDialer would without an HTTP client override.
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx,
+		option.WithHTTPClient(&http.Client{Transport: &oauth2.Transport{
+			Source: d.iamTokenSource,
+			Base:   authCheckingTransport{wantToken: "fresh", base: mc.Transport},
+		}}),
+		option.WithEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	d.client = c
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if err := d.Warmup(ctx, instURI); err == nil {
+		t.Fatal("want Warmup to fail with the stale token, got nil")
+	}
+	if got := atomic.LoadInt32(&refreshes); got != 1 {
+		t.Fatalf("token source refreshes mismatch, want = 1, got = %v", got)
+	}
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed after token refresh, got error: %v", err)
+	}
+}
+
+// failingTokenSource always fails to provide a token.
+type failingTokenSource struct{}
+
+func (failingTokenSource) Token() (*oauth2.Token, error) {
+	return nil, errors.New("metadata server unavailable")
+}
+
+func TestDialerWithTokenSourceFailover(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	fallback := &spyTokenSource{token: "fallback"}
+	d, err := NewDialer(ctx,
+		WithTokenSourceFailover(failingTokenSource{}, fallback),
+		WithIAMAuthN(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	// Authenticate the Admin API client with the dialer's token source, as
+	// NewDialer would without an HTTP client override.
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx,
+		option.WithHTTPClient(&http.Client{Transport: &oauth2.Transport{
+			Source: d.iamTokenSource,
+			Base:   authCheckingTransport{wantToken: "fallback", base: mc.Transport},
+		}}),
+		option.WithEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	d.client = c
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	if fallback.Calls() == 0 {
+		t.Fatal("expected fallback token source to be called")
+	}
+}
+
+func TestDialerWithTokenSourceFailoverErrors(t *testing.T) {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "my-token"})
+	tcs := []struct {
+		desc string
+		opts []Option
+	}{
+		{
+			desc: "with a nil fallback",
+			opts: []Option{WithTokenSourceFailover(ts, nil)},
+		},
+		{
+			desc: "combined with WithTokenSource",
+			opts: []Option{WithTokenSource(ts), WithTokenSourceFailover(ts, ts)},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(), tc.opts...)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFailoverTokenSourceBothFail(t *testing.T) {
+	ts := failoverTokenSource{primary: failingTokenSource{}, fallback: failingTokenSource{}}
+	_, err := ts.Token()
+	if err == nil || !strings.Contains(err.Error(), "fallback token source failed") {
+		t.Fatalf("want error from both token sources, got = %v", err)
+	}
+}
+
+// startConnectProxy starts an HTTP CONNECT proxy that tunnels a single
+// connection and reports the CONNECT request it received.
+func startConnectProxy(t *testing.T) (string, <-chan *http.Request) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		reqs <- req
+		if req.Header.Get("Proxy-Authorization") == "" {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+			return
+		}
+		defer target.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}()
+	return l.Addr().String(), reqs
+}
+
+func TestDialerWithHTTPProxy(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	proxyAddr, reqs := startConnectProxy(t)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithHTTPProxy("http://user:secret@"+proxyAddr))
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+
+	req := <-reqs
+	if req.Method != http.MethodConnect || req.Host != "127.0.0.1:5433" {
+		t.Fatalf("want CONNECT 127.0.0.1:5433, got = %v %v", req.Method, req.Host)
+	}
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	if got := req.Header.Get("Proxy-Authorization"); got != wantAuth {
+		t.Fatalf("want Proxy-Authorization = %v, got = %v", wantAuth, got)
+	}
+}
+
+func TestDialerWithHTTPProxyRefused(t *testing.T) {
+	proxyAddr, _ := startConnectProxy(t)
+	dial := httpProxyDialFunc(&neturl.URL{Scheme: "http", Host: proxyAddr}, defaultTCPKeepAlive)
+	_, err := dial(context.Background(), "tcp", "127.0.0.1:5433")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("want CONNECT to be refused without credentials, got = %v", err)
+	}
+}
+
+func TestDialerWithHTTPProxyErrors(t *testing.T) {
+	for _, u := range []string{"socks5://proxy:1080", "http://", "://bad"} {
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithHTTPProxy(u),
+		)
+		var wantErr *errtype.ConfigError
+		if !errors.As(err, &wantErr) {
+			t.Errorf("WithHTTPProxy(%q): want = %T, got = %v", u, wantErr, err)
+		}
+	}
+}
+
+func TestDialerWithHTTPProxyAndDialFunc(t *testing.T) {
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		return nil, errors.New("not used")
+	}
+	infoDial := func(context.Context, DialInfo) (net.Conn, error) {
+		return nil, errors.New("not used")
+	}
+	tcs := []struct {
+		desc string
+		opts []Option
+	}{
+		{
+			desc: "WithDialFunc first",
+			opts: []Option{WithDialFunc(dial), WithHTTPProxy("http://proxy:3128")},
+		},
+		{
+			desc: "WithHTTPProxy first",
+			opts: []Option{WithHTTPProxy("http://proxy:3128"), WithDialFunc(dial)},
+		},
+		{
+			desc: "WithContextDialFunc",
+			opts: []Option{WithHTTPProxy("http://proxy:3128"), WithContextDialFunc(infoDial)},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(),
+				append([]Option{WithTokenSource(stubTokenSource{})}, tc.opts...)...,
+			)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewNetDialerUsesKeepAlive(t *testing.T) {
+	d := newNetDialer(45 * time.Second)
+	if got, want := d.KeepAlive, 45*time.Second; got != want {
+		t.Fatalf("KeepAlive mismatch, want = %v, got = %v", want, got)
+	}
+	// The connect timeout is derived from the Dial context.
+	if d.Timeout != 0 {
+		t.Fatalf("want no fixed Timeout, got = %v", d.Timeout)
+	}
+}
+
+func TestDefaultDialFunc(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}()
+
+	f := defaultDialFunc(45 * time.Second)
+	conn, err := f(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("want dial to succeed, got = %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*net.TCPConn); !ok {
+		t.Fatalf("want = %T, got = %T", &net.TCPConn{}, conn)
+	}
+
+	// A canceled context aborts the dial.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f(ctx, "tcp", l.Addr().String()); err == nil {
+		t.Fatal("want dial with canceled context to fail, got nil")
+	}
+}
+
+func TestDialerForceRefresh(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	spy := &spyLogger{}
+	// The second set of calls is made by the forced refresh.
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	}, WithDebugLogger(spy))
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+
+	if err := d.ForceRefresh(instURI); err != nil {
+		t.Fatalf("expected ForceRefresh to succeed, but got error: %v", err)
+	}
+	// Wait for the forced refresh to complete.
+	for n := 0; n < 100; n++ {
+		if spy.Count("Refresh succeeded") == 2 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("want a second refresh after ForceRefresh")
+}
+
+func TestDialerForceRefreshErrors(t *testing.T) {
+	d, err := NewDialer(context.Background(), WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	tcs := []struct {
+		desc     string
+		instance string
+	}{
+		{desc: "malformed instance URI", instance: "bad-uri"},
+		{
+			desc:     "instance not dialed",
+			instance: "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := d.ForceRefresh(tc.instance)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDialerReportHealth(t *testing.T) {
+	tcs := []struct {
+		desc string
+		opts []Option
+	}{
+		{desc: "with background refresh"},
+		{desc: "with lazy refresh", opts: []Option{WithLazyRefresh()}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			// Certificates carry expiry times with second precision.
+			expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+			inst := mock.NewFakeInstance(
+				"my-project", "my-region", "my-cluster", "my-instance",
+				mock.WithCertExpiry(expiry),
+			)
+			mc, url, cleanup := mock.HTTPClient(
+				mock.InstanceGetSuccess(inst, 1),
+				mock.CreateEphemeralSuccess(inst, 1),
+			)
+			stop := mock.StartServerProxy(t, inst)
+			defer func() {
+				stop()
+				if err := cleanup(); err != nil {
+					t.Fatalf("%v", err)
+				}
+			}()
+			c, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+				ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+			if err != nil {
+				t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+			}
+
+			d, err := NewDialer(ctx, append(tc.opts, WithTokenSource(stubTokenSource{}))...)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			d.client = c
+			defer d.Close()
+
+			if got := d.ReportHealth(); len(got) != 0 {
+				t.Fatalf("want no instances before dialing, got = %v", got)
+			}
+
+			instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+			conn, err := d.Dial(ctx, instURI)
+			if err != nil {
+				t.Fatalf("expected Dial to succeed, but got error: %v", err)
+			}
+			defer conn.Close()
+
+			got, ok := d.ReportHealth()[instURI]
+			if !ok {
+				t.Fatalf("want health of %v, got = %v", instURI, d.ReportHealth())
+			}
+			if !got.CertExpiry.Equal(expiry) {
+				t.Errorf("want cert expiry = %v, got = %v", expiry, got.CertExpiry)
+			}
+			if got.LastRefresh.IsZero() {
+				t.Error("want last refresh to be set")
+			}
+			if got.LastRefreshErr != nil {
+				t.Errorf("want no refresh error, got = %v", got.LastRefreshErr)
+			}
+			if got.OpenConns != 1 {
+				t.Errorf("want open conns = 1, got = %v", got.OpenConns)
+			}
+		})
+	}
+}
+
+// closeSpyCache records whether the wrapped cache was closed.
+type closeSpyCache struct {
+	connectionInfoCache
+	closed int32
+}
+
+func (c *closeSpyCache) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.connectionInfoCache.Close()
+}
+
+func TestDialerWithMaxCachedInstances(t *testing.T) {
+	ctx := context.Background()
+	var (
+		insts []mock.FakeAlloyDBInstance
+		reqs  []*mock.Request
+	)
+	for _, name := range []string{"inst-1", "inst-2", "inst-3"} {
+		inst := mock.NewFakeInstance("my-project", "my-region", "my-cluster", name)
+		insts = append(insts, inst)
+		reqs = append(reqs,
+			mock.InstanceGetSuccess(inst, 1),
+			mock.CreateEphemeralSuccess(inst, 1),
+		)
+	}
+	mc, url, cleanup := mock.HTTPClient(reqs...)
+	stop := mock.StartServerProxy(t, insts[0])
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+		ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	spy := &spyLogger{}
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithDebugLogger(spy),
+		WithMaxCachedInstances(2),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	d.client = c
+	defer d.Close()
+
+	uri := func(name string) string {
+		return "projects/my-project/locations/my-region/clusters/my-cluster/instances/" + name
+	}
+	// inst-1 is dialed first and has no open connections.
+	conn, err := d.Dial(ctx, uri("inst-1"))
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	inst1, _ := alloydb.ParseInstURI(uri("inst-1"))
+	d.lock.Lock()
+	spyCache := &closeSpyCache{connectionInfoCache: d.instances[inst1]}
+	d.instances[inst1] = spyCache
+	d.lock.Unlock()
+
+	// inst-2 keeps an open connection.
+	conn, err = d.Dial(ctx, uri("inst-2"))
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+
+	// Dialing a third instance exceeds the limit and evicts inst-1.
+	conn, err = d.Dial(ctx, uri("inst-3"))
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+
+	health := d.ReportHealth()
+	if _, ok := health[uri("inst-1")]; ok {
+		t.Fatalf("want inst-1 evicted, got = %v", health)
+	}
+	for _, name := range []string{"inst-2", "inst-3"} {
+		if _, ok := health[uri(name)]; !ok {
+			t.Fatalf("want %v cached, got = %v", name, health)
+		}
+	}
+	if atomic.LoadInt32(&spyCache.closed) != 1 {
+		t.Fatal("want evicted instance to be closed")
+	}
+	if !spy.Contains("Evicting idle instance from cache") {
+		t.Fatal("want eviction to be logged")
+	}
+}
+
+func TestDialerWithMaxCachedInstancesErrors(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithMaxCachedInstances(n),
+		)
+		var cfgErr *errtype.ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("WithMaxCachedInstances(%v): want ConfigError, got = %v", n, err)
+		}
+	}
+}
+
+func TestDialerWithInstanceIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	var (
+		insts []mock.FakeAlloyDBInstance
+		reqs  []*mock.Request
+	)
+	for _, name := range []string{"inst-1", "inst-2"} {
+		inst := mock.NewFakeInstance("my-project", "my-region", "my-cluster", name)
+		insts = append(insts, inst)
+		reqs = append(reqs,
+			mock.InstanceGetSuccess(inst, 1),
+			mock.CreateEphemeralSuccess(inst, 1),
+		)
+	}
+	mc, url, cleanup := mock.HTTPClient(reqs...)
+	stop := mock.StartServerProxy(t, insts[0])
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+		ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithInstanceIdleTimeout(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	d.client = c
+	var (
+		mu  sync.Mutex
+		now = time.Now()
+	)
+	d.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(by time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(by)
+	}
+
+	uri := func(name string) string {
+		return "projects/my-project/locations/my-region/clusters/my-cluster/instances/" + name
+	}
+	// inst-1 has no open connections, while inst-2 keeps one open.
+	conn, err := d.Dial(ctx, uri("inst-1"))
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	inst1, _ := alloydb.ParseInstURI(uri("inst-1"))
+	d.lock.Lock()
+	spyCache := &closeSpyCache{connectionInfoCache: d.instances[inst1]}
+	d.instances[inst1] = spyCache
+	d.lock.Unlock()
+	conn, err = d.Dial(ctx, uri("inst-2"))
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+
+	// Nothing is reaped before the timeout.
+	advance(time.Hour)
+	d.reapIdle()
+	if got := len(d.ReportHealth()); got != 2 {
+		t.Fatalf("want 2 cached instances, got = %v", got)
+	}
+
+	advance(time.Second)
+	d.reapIdle()
+	health := d.ReportHealth()
+	if _, ok := health[uri("inst-1")]; ok {
+		t.Fatalf("want idle inst-1 closed, got = %v", health)
+	}
+	if _, ok := health[uri("inst-2")]; !ok {
+		t.Fatalf("want inst-2 with an open connection cached, got = %v", health)
+	}
+	if atomic.LoadInt32(&spyCache.closed) != 1 {
+		t.Fatal("want idle instance to be closed")
+	}
+
+	// Closing the Dialer stops the sweeper.
+	d.Close()
+	select {
+	case <-d.sweeperDone:
+	default:
+		t.Fatal("want sweeper stopped after Close")
+	}
+}
+
+func TestDialerWithInstanceIdleTimeoutErrors(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithInstanceIdleTimeout(timeout),
+		)
+		var cfgErr *errtype.ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("WithInstanceIdleTimeout(%v): want ConfigError, got = %v", timeout, err)
+		}
+	}
+}
+
+func TestDialerWithDialResult(t *testing.T) {
+	ctx := context.Background()
+	// Certificates carry expiry times with second precision.
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(expiry),
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	var res DialResult
+	conn, err := d.Dial(ctx,
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		WithDialResult(&res),
+	)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+
+	want := DialResult{
+		IPAddress:  "127.0.0.1",
+		IPType:     alloydb.PrivateIP,
+		CertExpiry: expiry,
+	}
+	if res.IPAddress != want.IPAddress || res.IPType != want.IPType ||
+		!res.CertExpiry.Equal(want.CertExpiry) {
+		t.Fatalf("want dial result = %+v, got = %+v", want, res)
+	}
+}
+
+func TestDialerWithRefreshTimeoutErrors(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithRefreshTimeout(timeout),
+		)
+		var cfgErr *errtype.ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("WithRefreshTimeout(%v): want ConfigError, got = %v", timeout, err)
+		}
+	}
+}
+
+func TestDialerRefreshRateLimit(t *testing.T) {
+	tcs := []struct {
+		desc      string
+		opts      []Option
+		wantEvery time.Duration
+		wantBurst int
+	}{
+		{
+			desc:      "default configuration",
+			wantEvery: 30 * time.Second,
+			wantBurst: 2,
+		},
+		{
+			desc:      "with a custom rate limit",
+			opts:      []Option{WithRefreshRateLimit(time.Minute, 5)},
+			wantEvery: time.Minute,
+			wantBurst: 5,
+		},
+		{
+			desc: "without a rate limit",
+			opts: []Option{WithNoRefreshRateLimit()},
+		},
+		{
+			desc: "with lazy refresh",
+			opts: []Option{WithLazyRefresh()},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			d, err := NewDialer(context.Background(),
+				append(tc.opts, WithTokenSource(stubTokenSource{}))...,
+			)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+			every, burst := d.RefreshRateLimit()
+			if every != tc.wantEvery || burst != tc.wantBurst {
+				t.Fatalf("want rate limit = (%v, %v), got = (%v, %v)",
+					tc.wantEvery, tc.wantBurst, every, burst)
+			}
+		})
+	}
+}
+
+func TestDialerWithRefreshRateLimitErrors(t *testing.T) {
+	tcs := []struct {
+		desc  string
+		every time.Duration
+		burst int
+	}{
+		{desc: "with a zero burst", every: time.Minute, burst: 0},
+		{desc: "with a zero interval", every: 0, burst: 2},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(),
+				WithTokenSource(stubTokenSource{}),
+				WithRefreshRateLimit(tc.every, tc.burst),
+			)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDialerWarnsWhenRefreshTimeoutNotGreaterThanInterval(t *testing.T) {
+	tcs := []struct {
+		desc string
+		opts []Option
+		warn bool
+	}{
+		{
+			desc: "default configuration",
+		},
+		{
+			desc: "timeout shorter than the default interval",
+			opts: []Option{WithRefreshTimeout(5 * time.Second)},
+			warn: true,
+		},
+		{
+			desc: "timeout equal to a custom interval",
+			opts: []Option{
+				WithRefreshTimeout(time.Minute),
+				WithRefreshRateLimit(time.Minute, 2),
+			},
+			warn: true,
+		},
+		{
+			desc: "timeout greater than a custom interval",
+			opts: []Option{
+				WithRefreshTimeout(5 * time.Second),
+				WithRefreshRateLimit(time.Second, 2),
+			},
+		},
+		{
+			desc: "lazy refresh doesn't rate limit",
+			opts: []Option{WithRefreshTimeout(5 * time.Second), WithLazyRefresh()},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			spy := &spyLogger{}
+			d, err := NewDialer(context.Background(), append(tc.opts,
+				WithTokenSource(stubTokenSource{}),
+				WithDebugLogger(spy),
+			)...)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+			if got := spy.Contains("is not greater than the refresh rate limit interval"); got != tc.warn {
+				t.Fatalf("want warning logged = %v, got = %v", tc.warn, got)
+			}
+		})
+	}
+}
+
+func TestDialReturnsWhenContextEndsDuringInitialRefresh(t *testing.T) {
+	tcs := []struct {
+		desc string
+		opts []Option
+	}{
+		{desc: "with background refresh"},
+		{desc: "with lazy refresh", opts: []Option{WithLazyRefresh()}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			// The Admin API doesn't respond until the test ends.
+			release := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-release:
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer ts.Close()
+
+			d, err := NewDialer(context.Background(), append(tc.opts,
+				WithTokenSource(stubTokenSource{}),
+				WithAdminAPIEndpoint(ts.URL),
+			)...)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+			defer close(release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err = d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("want = %v, got = %v", context.DeadlineExceeded, err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("want Dial to return once its context ends, took %v", elapsed)
+			}
+			// The instance stays cached, so the refresh can complete in
+			// the background and serve later calls to Dial.
+			if got := len(d.ReportHealth()); got != 1 {
+				t.Fatalf("want instance to remain cached, got %v cached instances", got)
+			}
+		})
+	}
+}
+
+func TestDialerWithContextDialFunc(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	var got DialInfo
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		WithContextDialFunc(func(ctx context.Context, info DialInfo) (net.Conn, error) {
+			got = info
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", net.JoinHostPort(info.IPAddress, "5433"))
+		}),
+	)
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+
+	if got.Instance.URI() != instURI {
+		t.Errorf("want instance = %v, got = %v", instURI, got.Instance.URI())
+	}
+	if got.IPAddress != "127.0.0.1" {
+		t.Errorf("want IP address = %v, got = %v", "127.0.0.1", got.IPAddress)
+	}
+	if got.IPType != alloydb.PrivateIP {
+		t.Errorf("want IP type = %v, got = %v", alloydb.PrivateIP, got.IPType)
+	}
+}
+
+func TestDialerWithCachedKeyFromDisk(t *testing.T) {
+	t.Run("generate and persist", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		d1, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithCachedKeyFromDisk(path),
+		)
+		if err != nil {
+			t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+		}
+		defer d1.Close()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("want key persisted, got error: %v", err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Fatalf("want key file permissions = 0600, got = %v", perm)
+		}
+
+		d2, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithCachedKeyFromDisk(path),
+		)
+		if err != nil {
+			t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+		}
+		defer d2.Close()
+		if !d1.key.Equal(d2.key) {
+			t.Fatal("want the persisted key to be reused")
+		}
+	})
+	t.Run("load existing", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate RSA key: %v", err)
+		}
+		path := filepath.Join(t.TempDir(), "key.pem")
+		b := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+
+		d, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithCachedKeyFromDisk(path),
+		)
+		if err != nil {
+			t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+		}
+		defer d.Close()
+		if !d.key.Equal(key) {
+			t.Fatal("want the existing key to be loaded")
+		}
+	})
+	t.Run("regenerate corrupt key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		if err := os.WriteFile(path, []byte("not a key"), 0600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+
+		spy := &spyLogger{}
+		d, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithDebugLogger(spy),
+			WithCachedKeyFromDisk(path),
+		)
+		if err != nil {
+			t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+		}
+		defer d.Close()
+		if !spy.Contains("Failed to load cached RSA key") {
+			t.Fatal("want the corrupt key to be logged")
+		}
+		key, err := loadKey(path, 2048)
+		if err != nil {
+			t.Fatalf("want the corrupt key replaced, got error: %v", err)
+		}
+		if !d.key.Equal(key) {
+			t.Fatal("want the regenerated key to be persisted")
+		}
+	})
+}
+
+func TestDialerWithRefreshRetry(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// Requests are matched in order, so the first two calls fail.
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetError(inst, http.StatusServiceUnavailable, 2),
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithRefreshRetry(3))
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerWithRefreshRetryErrors(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithRefreshRetry(n),
+		)
+		var cfgErr *errtype.ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("WithRefreshRetry(%v): want ConfigError, got = %v", n, err)
+		}
+	}
+}
+
+func TestDialerCachedInstances(t *testing.T) {
+	ctx := context.Background()
+	var (
+		insts []mock.FakeAlloyDBInstance
+		reqs  []*mock.Request
+	)
+	for _, name := range []string{"inst-1", "inst-2"} {
+		inst := mock.NewFakeInstance("my-project", "my-region", "my-cluster", name)
+		insts = append(insts, inst)
+		reqs = append(reqs,
+			mock.InstanceGetSuccess(inst, 1),
+			mock.CreateEphemeralSuccess(inst, 1),
+		)
+	}
+	mc, url, cleanup := mock.HTTPClient(reqs...)
+	stop := mock.StartServerProxy(t, insts[0])
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	if got := d.CachedInstances(); len(got) != 0 {
+		t.Fatalf("want no cached instances, got = %v", got)
+	}
+	for _, name := range []string{"inst-2", "inst-1"} {
+		conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/"+name)
+		if err != nil {
+			t.Fatalf("expected Dial to succeed, but got error: %v", err)
+		}
+		conn.Close()
+	}
+
+	want := []string{
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/inst-1",
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/inst-2",
+	}
+	if got := d.CachedInstances(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want cached instances = %v, got = %v", want, got)
+	}
+}
+
+func TestDialerCloseLeavesNoGoroutines(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+		ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	// Only goroutines started after this point are attributed to the Dialer.
+	before := runtime.NumGoroutine()
+
+	// An idle timeout also starts the idle instance sweeper.
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithInstanceIdleTimeout(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	d.client = c
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	if err := d.Close(); err != nil {
+		t.Fatalf("expected Close to succeed, but got error: %v", err)
+	}
+	// Connections kept alive by the HTTP client aren't owned by the Dialer.
+	mc.CloseIdleConnections()
+
+	// Goroutines serving closed connections exit asynchronously.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		after := runtime.NumGoroutine()
+		if after <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("want at most %d goroutines after Close, got %d:\n%s",
+				before, after, buf[:n])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDialerDrainAndClose(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+
+	// The open connection keeps DrainAndClose from returning before its
+	// context expires.
+	drainCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = d.DrainAndClose(drainCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want = %v, got = %v", context.DeadlineExceeded, err)
+	}
+
+	_, err = d.Dial(ctx, instURI)
+	if !errors.Is(err, ErrDialerClosing) {
+		t.Fatalf("want = %v, got = %v", ErrDialerClosing, err)
+	}
+
+	// Once the connection is closed, draining completes.
+	conn.Close()
+	if err := d.DrainAndClose(ctx); err != nil {
+		t.Fatalf("expected DrainAndClose to succeed, but got error: %v", err)
+	}
+}
+
+func TestDialerWithDialIPType(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// The Dialer defaults to public IP, which the instance doesn't have.
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithDefaultDialOptions(WithDialIPType(PublicIP)))
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	_, err := d.Dial(ctx, instURI)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when public IP is unavailable, want = %T, got = %v", wantErr, err)
+	}
+
+	// A per-Dial override selects the private IP for that call only.
+	var res DialResult
+	conn, err := d.Dial(ctx, instURI, WithDialIPType(PrivateIP), WithDialResult(&res))
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	if res.IPType != string(PrivateIP) || res.IPAddress != "127.0.0.1" {
+		t.Fatalf("want private IP 127.0.0.1, got = %+v", res)
+	}
+
+	_, err = d.Dial(ctx, instURI)
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("want the Dialer default to still apply, got = %v", err)
+	}
+}
+
+func TestDialerCertExpiry(t *testing.T) {
+	ctx := context.Background()
+	// Certificates carry expiry times with second precision.
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(expiry),
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	got, err := d.CertExpiry(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected CertExpiry to succeed, but got error: %v", err)
+	}
+	if !got.Equal(expiry) {
+		t.Fatalf("want expiry = %v, got = %v", expiry, got)
+	}
+
+	// Dial reuses the cached connection info.
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerWithOnConnectAndOnDisconnect(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	connected := make(chan InstanceURI, 1)
+	type disconnect struct {
+		inst     InstanceURI
+		lifetime time.Duration
+	}
+	disconnected := make(chan disconnect, 1)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		WithOnConnect(func(i InstanceURI) { connected <- i }),
+		WithOnDisconnect(func(i InstanceURI, lifetime time.Duration) {
+			disconnected <- disconnect{inst: i, lifetime: lifetime}
+		}),
+	)
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	select {
+	case got := <-connected:
+		if got.URI() != instURI {
+			t.Fatalf("OnConnect: want instance = %v, got = %v", instURI, got.URI())
+		}
+	default:
+		t.Fatal("OnConnect was not called before Dial returned")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := conn.Close(); err != nil {
+		t.Fatalf("expected Close to succeed, but got error: %v", err)
+	}
+	select {
+	case got := <-disconnected:
+		if got.inst.URI() != instURI {
+			t.Fatalf("OnDisconnect: want instance = %v, got = %v", instURI, got.inst.URI())
+		}
+		if got.lifetime < 10*time.Millisecond {
+			t.Fatalf("OnDisconnect: want lifetime >= 10ms, got = %v", got.lifetime)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect was not called after the connection closed")
+	}
+}
+
+func TestDialerWithLazyAdminClient(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithLazyAdminClient(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	var attempts int
+	d.newClient = func() (*alloydbadmin.AlloyDBAdminClient, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("network is unreachable")
+		}
+		return alloydbadmin.NewAlloyDBAdminRESTClient(
+			ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	}
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if _, err := d.Dial(ctx, instURI); err == nil {
+		t.Fatal("want Dial to fail when the client can't be created")
+	}
+	// Until the backoff delay passes, Dial fails without another attempt.
+	if _, err := d.Dial(ctx, instURI); err == nil {
+		t.Fatal("want Dial to fail during the backoff delay")
+	}
+	if attempts != 1 {
+		t.Fatalf("want 1 attempt during the backoff delay, got = %v", attempts)
+	}
+
+	now = now.Add(clientBackoffInitial)
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+	if attempts != 2 {
+		t.Fatalf("want 2 attempts, got = %v", attempts)
+	}
+}
+
+func TestValidateInstanceURI(t *testing.T) {
+	uris := []struct {
+		uri   string
+		valid bool
+	}{
+		{uri: "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance", valid: true},
+		{uri: "/projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance", valid: true},
+		{uri: "my-project:my-region:my-cluster:my-instance", valid: true},
+		{uri: "projects/google.com:my-project/locations/my-region/clusters/my-cluster/instances/my-instance", valid: true},
+		{uri: "google.com:my-project:my-region:my-cluster:my-instance", valid: true},
+		{uri: "projects/my-project/locations/my-region/clusters/my-cluster"},
+		{uri: "my-project:my-region:my-cluster"},
+		{uri: "extra:my-project:my-region:my-cluster:my-instance"},
+		{uri: ""},
+	}
+	// Validate all URIs up front and collect every invalid one, as tooling
+	// checking configuration would.
+	var invalid []string
+	for _, u := range uris {
+		err := ValidateInstanceURI(u.uri)
+		if err == nil {
+			continue
+		}
+		var wantErr *errtype.ConfigError
+		if !errors.As(err, &wantErr) {
+			t.Errorf("ValidateInstanceURI(%q): want = %T, got = %v", u.uri, wantErr, err)
+		}
+		invalid = append(invalid, u.uri)
+	}
+	var want []string
+	for _, u := range uris {
+		if !u.valid {
+			want = append(want, u.uri)
+		}
+	}
+	if !reflect.DeepEqual(invalid, want) {
+		t.Fatalf("invalid URIs mismatch, want = %q, got = %q", want, invalid)
+	}
+}
+
+func TestDialersWithSharedAdminClient(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// Each Dialer caches its own connection info.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	defer c.Close()
+
+	for _, opts := range [][]Option{
+		{WithAdminClient(c)},
+		{WithAdminClient(c), WithLazyRefresh()},
+	} {
+		d, err := NewDialer(ctx, append(opts, WithTokenSource(stubTokenSource{}))...)
+		if err != nil {
+			t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+		}
+		if d.client != c {
+			t.Fatal("want Dialer to use the shared admin client")
+		}
+		conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+		if err != nil {
+			t.Fatalf("expected Dial to succeed, but got error: %v", err)
+		}
+		conn.Close()
+		// Closing a Dialer leaves the shared client usable by the others.
+		d.Close()
+	}
+}
+
+func TestDialerWithNilAdminClient(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithAdminClient(nil),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when admin client is nil, want = %T, got = %v", wantErr, err)
+	}
+}
+
+// recordingTransport records the Authorization header of each request before
+// forwarding it to base.
+type recordingTransport struct {
+	mu    sync.Mutex
+	auths []string
+	base  http.RoundTripper
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.auths = append(r.auths, req.Header.Get("Authorization"))
+	r.mu.Unlock()
+	return r.base.RoundTrip(req)
+}
+
+func (r *recordingTransport) Last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.auths) == 0 {
+		return ""
+	}
+	return r.auths[len(r.auths)-1]
+}
+
+func TestDialerUpdateCredentials(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "old"})),
+		// Lazy refresh avoids background refreshes racing the test.
+		WithLazyRefresh(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	// Authenticate the Admin API client with the dialer's token source, as
+	// NewDialer would without an HTTP client override.
+	rt := &recordingTransport{base: mc.Transport}
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx,
+		option.WithHTTPClient(&http.Client{Transport: &oauth2.Transport{
+			Source: d.iamTokenSource,
+			Base:   rt,
+		}}),
+		option.WithEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	d.client = c
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	if got := rt.Last(); got != "Bearer old" {
+		t.Fatalf("want refresh with the old token, got = %q", got)
+	}
+
+	if err := d.UpdateCredentials(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "new"})); err != nil {
+		t.Fatalf("expected UpdateCredentials to succeed, but got error: %v", err)
+	}
+	if err := d.ForceRefresh(instURI); err != nil {
+		t.Fatalf("expected ForceRefresh to succeed, but got error: %v", err)
+	}
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	if got := rt.Last(); got != "Bearer new" {
+		t.Fatalf("want refresh with the new token, got = %q", got)
+	}
+}
+
+func TestDialerUpdateCredentialsRecreatesClient(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	// The client created from a credentials file doesn't use a replaceable
+	// token source.
+	d, err := NewDialer(ctx,
+		WithCredentialsFile(writeFakeCredentialsFile(t, "my-token")),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	// Open connections are unaffected by the update.
+	defer conn.Close()
+
+	oldClient := d.client
+	if err := d.UpdateCredentials(&spyTokenSource{token: "new"}); err != nil {
+		t.Fatalf("expected UpdateCredentials to succeed, but got error: %v", err)
+	}
+	if d.client == oldClient {
+		t.Fatal("want UpdateCredentials to create a new admin client")
+	}
+	if got := d.CachedInstances(); len(got) != 0 {
+		t.Fatalf("want cached instances to be dropped, got = %v", got)
+	}
+	conn2, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn2.Close()
+}
+
+func TestDialerUpdateCredentialsErrors(t *testing.T) {
+	ctx := context.Background()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+	defer c.Close()
+	d, err := NewDialer(ctx, WithTokenSource(stubTokenSource{}), WithAdminClient(c))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	for _, ts := range []oauth2.TokenSource{nil, stubTokenSource{}} {
+		err := d.UpdateCredentials(ts)
+		var wantErr *errtype.ConfigError
+		if !errors.As(err, &wantErr) {
+			t.Errorf("UpdateCredentials(%v): want = %T, got = %v", ts, wantErr, err)
+		}
+	}
+}
+
+func TestDialerWithRequiredIPVersion(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	// The fake instance only has an IPv4 address.
+	for _, tc := range []struct {
+		v       IPVersion
+		wantErr bool
+	}{
+		{v: IPv4},
+		{v: IPv6, wantErr: true},
+	} {
+		t.Run(tc.v.String(), func(t *testing.T) {
+			d := newTestDialer(t, inst, []*mock.Request{
+				mock.InstanceGetSuccess(inst, 1),
+				mock.CreateEphemeralSuccess(inst, 1),
+			}, WithRequiredIPVersion(tc.v))
+			conn, err := d.Dial(ctx, instURI)
+			if tc.wantErr {
+				var wantErr *errtype.ConfigError
+				if !errors.As(err, &wantErr) {
+					t.Fatalf("when IP version is unavailable, want = %T, got = %v", wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected Dial to succeed, but got error: %v", err)
+			}
+			conn.Close()
+		})
+	}
+}
+
+func TestCheckIPVersion(t *testing.T) {
+	inst, err := alloydb.ParseInstURI("my-project:my-region:my-cluster:my-instance")
+	if err != nil {
+		t.Fatalf("failed to parse instance URI: %v", err)
+	}
+	tcs := []struct {
+		addr string
+		v    IPVersion
+		ok   bool
+	}{
+		{addr: "10.0.0.1", v: AnyIPVersion, ok: true},
+		{addr: "10.0.0.1", v: IPv4, ok: true},
+		{addr: "10.0.0.1", v: IPv6},
+		{addr: "fd00::1", v: AnyIPVersion, ok: true},
+		{addr: "fd00::1", v: IPv6, ok: true},
+		{addr: "fd00::1", v: IPv4},
+		{addr: "x.y.alloydb-psc.goog", v: AnyIPVersion, ok: true},
+		{addr: "x.y.alloydb-psc.goog", v: IPv4},
+	}
+	for _, tc := range tcs {
+		err := checkIPVersion(inst, alloydb.PrivateIP, tc.addr, tc.v)
+		if got := err == nil; got != tc.ok {
+			t.Errorf("checkIPVersion(%v, %v): want ok = %v, got err = %v", tc.addr, tc.v, tc.ok, err)
+		}
+	}
+}
+
+func TestDialerWithInvalidIPVersion(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithRequiredIPVersion(IPVersion(42)),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when IP version is invalid, want = %T, got = %v", wantErr, err)
+	}
+}
+
+// staleCertCache is a connectionInfoCache that returns a TLS config that fails
+// server verification until ForceRefresh is called, as happens when the
+// instance's certificates rotate between retrieving the connection info and
+// the TLS handshake.
+type staleCertCache struct {
+	connectionInfoCache
+	mu        sync.Mutex
+	refreshed bool
+	calls     int
+}
+
+func (s *staleCertCache) ConnectInfo(ctx context.Context, ipType string) (string, *tls.Config, error) {
+	addr, cfg, err := s.connectionInfoCache.ConnectInfo(ctx, ipType)
+	if err != nil {
+		return "", nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if !s.refreshed {
+		cfg = cfg.Clone()
+		cfg.RootCAs = x509.NewCertPool()
+	}
+	return addr, cfg, nil
+}
+
+func (s *staleCertCache) ForceRefresh() {
+	s.mu.Lock()
+	s.refreshed = true
+	s.mu.Unlock()
+	s.connectionInfoCache.ForceRefresh()
+}
+
+func TestDialerRetriesAfterCertificateVerificationFailure(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	})
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	cn, _ := alloydb.ParseInstURI(instURI)
+	i, err := d.instance(cn, 0)
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	spy := &staleCertCache{connectionInfoCache: i}
+	d.lock.Lock()
+	d.instances[cn] = spy
+	d.lock.Unlock()
+
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("want Dial to succeed after refresh, got = %v", err)
+	}
+	defer conn.Close()
+
+	spy.mu.Lock()
+	defer spy.mu.Unlock()
+	if !spy.refreshed {
+		t.Fatal("want ForceRefresh to be called after verification failure")
+	}
+	if spy.calls != 2 {
+		t.Fatalf("want ConnectInfo to be called twice, got = %v", spy.calls)
+	}
+}
+
+func TestWithQuotaProject(t *testing.T) {
+	cfg := &dialerConfig{}
+	WithQuotaProject("billing-project")(cfg)
+	if cfg.err != nil {
+		t.Fatalf("want no error, got = %v", cfg.err)
+	}
+	want := []option.ClientOption{option.WithQuotaProject("billing-project")}
+	if !reflect.DeepEqual(cfg.adminOpts, want) {
+		t.Fatalf("admin client options mismatch, want = %v, got = %v", want, cfg.adminOpts)
+	}
+
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithQuotaProject(""),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when quota project is empty, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialerConnectionInfo(t *testing.T) {
+	ctx := context.Background()
+	// Certificates carry expiry times with second precision.
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr("10.0.0.2"),
+		mock.WithCertExpiry(expiry),
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		WithDialFunc(func(context.Context, string, string) (net.Conn, error) {
+			t.Error("want ConnectionInfo not to open a connection")
+			return nil, errors.New("unexpected dial")
+		}),
+	)
+
+	got, err := d.ConnectionInfo(ctx,
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+	)
+	if err != nil {
+		t.Fatalf("expected ConnectionInfo to succeed, but got error: %v", err)
+	}
+	want := ConnectionInfo{
+		IPAddress:  "10.0.0.2",
+		IPType:     PrivateIP,
+		CertExpiry: expiry,
+	}
+	if got.IPAddress != want.IPAddress || got.IPType != want.IPType ||
+		!got.CertExpiry.Equal(want.CertExpiry) {
+		t.Fatalf("want = %+v, got = %+v", want, got)
+	}
+}
+
+// inFlightTransport tracks the maximum number of concurrent requests whose path
+// contains pathSubstr.
+type inFlightTransport struct {
+	pathSubstr string
+	base       http.RoundTripper
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *inFlightTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.Contains(req.URL.Path, f.pathSubstr) {
+		return f.base.RoundTrip(req)
+	}
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+	// Hold the request open so that concurrent refreshes overlap.
+	time.Sleep(20 * time.Millisecond)
+	return f.base.RoundTrip(req)
+}
+
+func (f *inFlightTransport) Max() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxInFlight
+}
+
+func TestDialerWithMaxConcurrentRefreshes(t *testing.T) {
+	ctx := context.Background()
+	const (
+		instanceCount = 8
+		maxRefreshes  = 2
+	)
+	var (
+		insts []mock.FakeAlloyDBInstance
+		reqs  []*mock.Request
+	)
+	for n := 0; n < instanceCount; n++ {
+		inst := mock.NewFakeInstance(
+			"my-project", "my-region", "my-cluster", fmt.Sprintf("my-instance-%d", n),
+		)
+		insts = append(insts, inst)
+		reqs = append(reqs, mock.InstanceGetSuccess(inst, 1))
+	}
+	// Ephemeral certificates are generated per cluster.
+	reqs = append(reqs, mock.CreateEphemeralSuccess(insts[0], instanceCount))
+	mc, url, cleanup := mock.HTTPClient(reqs...)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	rt := &inFlightTransport{pathSubstr: ":generateClientCertificate", base: mc.Transport}
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(&http.Client{Transport: rt}),
+		WithAdminAPIEndpoint(url),
+		WithMaxConcurrentRefreshes(maxRefreshes),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	var wg sync.WaitGroup
+	for n := 0; n < instanceCount; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			instURI := fmt.Sprintf(
+				"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance-%d", n,
+			)
+			if err := d.Warmup(ctx, instURI); err != nil {
+				t.Errorf("expected Warmup to succeed, but got error: %v", err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if got := rt.Max(); got > maxRefreshes {
+		t.Fatalf("want at most %v concurrent refreshes, got = %v", maxRefreshes, got)
+	}
+}
+
+func TestDialerWithMaxConcurrentRefreshesErrors(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithMaxConcurrentRefreshes(0),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when max concurrent refreshes is invalid, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialerWithRefreshBudget(t *testing.T) {
+	ctx := context.Background()
+	const budget = 2
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	r := &fakeRefresher{inst: inst}
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(r),
+		WithRefreshBudget(budget),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	for n := 0; n < budget; n++ {
+		instURI := fmt.Sprintf(
+			"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance-%d", n,
+		)
+		if err := d.Warmup(ctx, instURI); err != nil {
+			t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+		}
+	}
+	err = d.Warmup(ctx,
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance-throttled",
+	)
+	var refreshErr *errtype.RefreshError
+	if !errors.As(err, &refreshErr) {
+		t.Fatalf("when the budget is exhausted, want = %T, got = %v", refreshErr, err)
+	}
+	if !errors.Is(err, ErrRefreshBudgetExhausted) {
+		t.Fatalf("want error to wrap %v, got = %v", ErrRefreshBudgetExhausted, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls != budget {
+		t.Fatalf("want %v refreshes within the budget, got = %v", budget, r.calls)
+	}
+}
+
+func TestDialerWithRefreshBudgetErrors(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithRefreshBudget(0),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when refresh budget is invalid, want = %T, got = %v", wantErr, err)
+	}
+}
+
+// blockingTransport blocks every request until release is closed.
+type blockingTransport struct {
+	release chan struct{}
+	base    http.RoundTripper
+}
+
+func (b *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-b.release
+	return b.base.RoundTrip(req)
+}
+
+func TestDialerWithFailFast(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	rt := &blockingTransport{release: make(chan struct{}), base: mc.Transport}
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(&http.Client{Transport: rt}),
+		WithAdminAPIEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	// The refresh of the cold instance can't complete until the Admin API
+	// requests are released.
+	_, err = d.Dial(ctx, instURI, WithFailFast())
+	if !errors.Is(err, ErrConnectionInfoUnavailable) {
+		t.Fatalf("want = %v, got = %v", ErrConnectionInfoUnavailable, err)
+	}
+	var dialErr *errtype.DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("want = %T, got = %v", dialErr, err)
+	}
+
+	close(rt.release)
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	conn, err := d.Dial(ctx, instURI, WithFailFast())
+	if err != nil {
+		t.Fatalf("expected Dial to succeed once the refresh completes, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerContextCancellationStopsRefreshes(t *testing.T) {
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	rt := &recordingTransport{base: mc.Transport}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(&http.Client{Transport: rt}),
+		WithAdminAPIEndpoint(url),
+		WithNoRefreshRateLimit(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if err := d.Warmup(context.Background(), instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	rt.mu.Lock()
+	want := len(rt.auths)
+	rt.mu.Unlock()
+
+	cancel()
+	// Without the dialer's context, a forced refresh would call the Admin
+	// API again.
+	if err := d.ForceRefresh(instURI); err != nil {
+		t.Fatalf("expected ForceRefresh to succeed, but got error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	rt.mu.Lock()
+	got := len(rt.auths)
+	rt.mu.Unlock()
+	if got != want {
+		t.Fatalf("want no Admin API requests after cancellation, got = %v", got-want)
+	}
+}
+
+func TestDialerCurrentRefreshAge(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	_, err := d.CurrentRefreshAge(instURI)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when instance has not been dialed, want = %T, got = %v", wantErr, err)
+	}
+
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	first, err := d.CurrentRefreshAge(instURI)
+	if err != nil {
+		t.Fatalf("expected CurrentRefreshAge to succeed, but got error: %v", err)
+	}
+	if first == RefreshInProgress {
+		t.Fatal("want a completed refresh after Warmup, got RefreshInProgress")
+	}
+	time.Sleep(10 * time.Millisecond)
+	second, err := d.CurrentRefreshAge(instURI)
+	if err != nil {
+		t.Fatalf("expected CurrentRefreshAge to succeed, but got error: %v", err)
+	}
+	if second <= first {
+		t.Fatalf("want age to increase, got %v then %v", first, second)
+	}
+}
+
+// fakeRefresher issues connection info for a fake instance without the
+// AlloyDB Admin API.
+type fakeRefresher struct {
+	inst mock.FakeAlloyDBInstance
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeRefresher) Refresh(_ context.Context, _ InstanceURI, key *rsa.PrivateKey) (RefreshResult, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	cert, pool, err := f.inst.ClientCert(key)
+	if err != nil {
+		return RefreshResult{}, err
+	}
+	return RefreshResult{
+		IPAddrs:    map[IPType]string{PrivateIP: "127.0.0.1"},
+		ClientCert: cert,
+		RootCAs:    pool,
+		Expiry:     time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestDialerWithRefresher(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// No Admin API requests are expected.
+	mc, url, cleanup := mock.HTTPClient()
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	r := &fakeRefresher{inst: inst}
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+		WithRefresher(r),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	conn.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls != 1 {
+		t.Fatalf("want Refresh to be called once, got = %v", r.calls)
+	}
+}
+
+func TestDialerWithNilRefresher(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(nil),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when refresher is nil, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialFailuresRecord(t *testing.T) {
+	inst, _ := alloydb.ParseInstURI("my-project:my-region:my-cluster:my-instance")
+	start := time.Now()
+	tcs := []struct {
+		desc  string
+		after time.Duration
+		want  bool
+	}{
+		{desc: "first failure", after: 0, want: false},
+		{desc: "second failure within the window", after: time.Second, want: true},
+		{desc: "third failure right after a forced refresh", after: 2 * time.Second, want: false},
+		{desc: "fourth failure within the cooldown", after: 3 * time.Second, want: false},
+		{desc: "first failure after the window", after: time.Minute, want: false},
+		{desc: "second failure after the cooldown", after: time.Minute + time.Second, want: true},
+	}
+	var f dialFailures
+	for _, tc := range tcs {
+		if got := f.record(inst, start.Add(tc.after)); got != tc.want {
+			t.Fatalf("%v: want force refresh = %v, got = %v", tc.desc, tc.want, got)
+		}
+	}
+
+	// A successful dial forgets earlier failures.
+	f.reset(inst)
+	if f.record(inst, start.Add(2*time.Minute)) {
+		t.Fatal("want no forced refresh after the first failure following a reset")
+	}
+}
+
+func TestDialerForcesRefreshAfterRepeatedDialFailures(t *testing.T) {
+	ctx := context.Background()
+	// Maintenance moves the instance from oldIP to 127.0.0.1, where the
+	// server side proxy listens.
+	const oldIP = "127.0.0.2"
+	oldInst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr(oldIP),
+	)
+	newInst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(oldInst, 1),
+		mock.InstanceGetSuccess(newInst, 1),
+		mock.CreateEphemeralSuccess(newInst, 2),
+	)
+	stop := mock.StartServerProxy(t, newInst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+		WithDialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasPrefix(addr, oldIP+":") {
+				return nil, syscall.ECONNREFUSED
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	for n := 0; n < dialFailureThreshold; n++ {
+		if _, err := d.Dial(ctx, instURI); !errors.Is(err, syscall.ECONNREFUSED) {
+			t.Fatalf("dial %d: want = %v, got = %v", n, syscall.ECONNREFUSED, err)
+		}
+	}
+
+	// The forced refresh picks up the new IP address.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := d.ConnectionInfo(ctx, instURI)
+		if err != nil {
+			t.Fatalf("expected ConnectionInfo to succeed, but got error: %v", err)
+		}
+		if info.IPAddress == "127.0.0.1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want IP address to be refreshed, got = %v", info.IPAddress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err := d.Dial(ctx, instURI)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed after the refresh, but got error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerWithDialTimeout(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		WithDialTimeout(50*time.Millisecond),
+		// The dial hangs, as when the network drops packets.
+		WithDialFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+			select {
+			case <-time.After(10 * time.Second):
+				return nil, errors.New("dial was not canceled")
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}),
+	)
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	// Retrieve the connection info first, so only the dial is timed.
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	start := time.Now()
+	_, err := d.Dial(ctx, instURI)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want = %v, got = %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("want Dial to time out after the dial timeout, took %v", elapsed)
+	}
+}
+
+func TestDialerWithDialTimeoutErrors(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithDialTimeout(0),
+	)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when dial timeout is invalid, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialerWarmupAll(t *testing.T) {
+	ctx := context.Background()
+	okInst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "ok-instance",
+	)
+	deniedInst := mock.NewFakeInstance(
+		"my-project", "my-region", "other-cluster", "denied-instance",
+	)
+	// The instances are in different clusters, so that only the OK
+	// instance gets an ephemeral certificate.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(okInst, 1),
+		mock.CreateEphemeralSuccess(okInst, 1),
+		mock.InstanceGetError(deniedInst, http.StatusForbidden, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	if err := d.WarmupAll(ctx, nil); err != nil {
+		t.Fatalf("want no error without instances, got = %v", err)
+	}
+
+	err = d.WarmupAll(ctx, []string{
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/ok-instance",
+		"not-an-instance-uri",
+		"projects/my-project/locations/my-region/clusters/other-cluster/instances/denied-instance",
+	})
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("want an error that wraps several errors, got = %v", err)
+	}
+	errs := joined.Unwrap()
+	if len(errs) != 2 {
+		t.Fatalf("want 2 errors, got = %v", errs)
+	}
+	var configErr *errtype.ConfigError
+	if !errors.As(errs[0], &configErr) {
+		t.Errorf("want = %T for the invalid URI, got = %v", configErr, errs[0])
+	}
+	var refreshErr *errtype.RefreshError
+	if !errors.As(errs[1], &refreshErr) {
+		t.Errorf("want = %T for the denied instance, got = %v", refreshErr, errs[1])
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		t.Errorf("want the aggregate to include the permission error, got = %v", err)
+	}
+}
+
+// hangingTransport never responds, as when the AlloyDB Admin API is slow.
+// Requests fail once their context is done.
+type hangingTransport struct{}
+
+func (hangingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestDialerWithInstanceRefreshTimeout(t *testing.T) {
+	ctx := context.Background()
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(&http.Client{Transport: hangingTransport{}}),
+		WithRefreshTimeout(time.Minute),
+		WithNoRefreshRateLimit(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	tcs := []struct {
+		instance string
+		timeout  time.Duration
+	}{
+		{
+			instance: "projects/my-project/locations/my-region/clusters/my-cluster/instances/fast-instance",
+			timeout:  50 * time.Millisecond,
+		},
+		{
+			instance: "projects/my-project/locations/my-region/clusters/my-cluster/instances/slow-instance",
+			timeout:  500 * time.Millisecond,
+		},
+	}
+	for _, tc := range tcs {
+		start := time.Now()
+		err := d.Warmup(ctx, tc.instance, WithInstanceRefreshTimeout(tc.timeout))
+		elapsed := time.Since(start)
+		if err == nil {
+			t.Fatalf("%v: want Warmup to fail when the refresh times out", tc.instance)
+		}
+		// The refresh fails once its timeout elapses, well before the
+		// Dialer's refresh timeout.
+		if elapsed < tc.timeout || elapsed > tc.timeout+5*time.Second {
+			t.Fatalf("%v: want refresh to time out after %v, took %v", tc.instance, tc.timeout, elapsed)
+		}
+	}
+}
+
+func TestDialerWithRESTAdminAPITransport(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr("10.0.0.2"),
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithAdminAPITransport(RESTTransport))
+
+	got, err := d.ConnectionInfo(ctx,
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+	)
+	if err != nil {
+		t.Fatalf("expected ConnectionInfo to succeed, but got error: %v", err)
+	}
+	if got.IPAddress != "10.0.0.2" {
+		t.Fatalf("want = %v, got = %v", "10.0.0.2", got.IPAddress)
+	}
+}
+
+func TestDialerWithGRPCAdminAPITransport(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr("10.0.0.2"),
+	)
+	addr, dialOpt, stop, err := mock.GRPCServer(inst)
+	if err != nil {
+		t.Fatalf("failed to start gRPC server: %v", err)
+	}
+	defer stop()
+
+	d, err := NewDialer(ctx,
+		WithAdminAPITransport(GRPCTransport),
+		WithTokenSource(stubTokenSource{}),
+		WithAdminAPIEndpoint(addr),
+		// Trust the test server's certificate.
+		func(c *dialerConfig) {
+			c.adminOpts = append(c.adminOpts, option.WithGRPCDialOption(dialOpt))
+		},
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	got, err := d.ConnectionInfo(ctx, uri)
+	if err != nil {
+		t.Fatalf("expected ConnectionInfo to succeed, but got error: %v", err)
+	}
+	if got.IPAddress != "10.0.0.2" {
+		t.Fatalf("want = %v, got = %v", "10.0.0.2", got.IPAddress)
+	}
+	v, err := d.EngineVersion(ctx, uri)
+	if err != nil {
+		t.Fatalf("expected EngineVersion to succeed, but got error: %v", err)
+	}
+	if v != "POSTGRES_15" {
+		t.Fatalf("want = %v, got = %v", "POSTGRES_15", v)
+	}
+}
+
+func TestDialerWithInvalidAdminAPITransport(t *testing.T) {
+	_, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithAdminAPITransport(Transport(7)),
+	)
+	var cfgErr *errtype.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("want = %T, got = %v", cfgErr, err)
+	}
+}
+
+func TestDialerRemoveInstance(t *testing.T) {
+	d, err := NewDialer(context.Background(), WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	inst, err := alloydb.ParseInstURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spy := &spyConnectionInfoCache{}
+	d.lock.Lock()
+	d.instances[inst] = spy
+	d.lock.Unlock()
+
+	if err := d.RemoveInstance(uri); err != nil {
+		t.Fatalf("expected RemoveInstance to succeed, but got error: %v", err)
+	}
+	if !spy.CloseWasCalled() {
+		t.Fatal("want the instance's cache to be closed, but it was not")
+	}
+	if got := d.CachedInstances(); len(got) != 0 {
+		t.Fatalf("want no cached instances, got = %v", got)
+	}
+
+	// The instance is no longer cached.
+	var cfgErr *errtype.ConfigError
+	if err := d.RemoveInstance(uri); !errors.As(err, &cfgErr) {
+		t.Fatalf("want = %T, got = %v", cfgErr, err)
+	}
+}
+
+func TestDialerRemoveInstanceWithOpenConnections(t *testing.T) {
+	d, err := NewDialer(context.Background(), WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	inst, err := alloydb.ParseInstURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spy := &spyConnectionInfoCache{openConns: 1}
+	d.lock.Lock()
+	d.instances[inst] = spy
+	d.lock.Unlock()
+
+	var cfgErr *errtype.ConfigError
+	if err := d.RemoveInstance(uri); !errors.As(err, &cfgErr) {
+		t.Fatalf("want = %T, got = %v", cfgErr, err)
+	}
+	if spy.CloseWasCalled() {
+		t.Fatal("want the instance's cache to stay open, but it was closed")
+	}
+	if got := d.CachedInstances(); len(got) != 1 {
+		t.Fatalf("want the instance to stay cached, got = %v", got)
+	}
+}
+
+func TestDialerID(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		WithDialFunc(func(context.Context, string, string) (net.Conn, error) {
+			return nil, errors.New("dial failed")
+		}),
+	)
+
+	id := d.ID()
+	if id == "" {
+		t.Fatal("want a non-empty dialer ID")
+	}
+	for n := 0; n < 2; n++ {
+		// The dials fail, but the ID must not change.
+		_, _ = d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+		if got := d.ID(); got != id {
+			t.Fatalf("want = %v, got = %v", id, got)
+		}
+	}
+
+	other, err := NewDialer(ctx, WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer other.Close()
+	if other.ID() == id {
+		t.Fatalf("want Dialers to have different IDs, both got %v", id)
+	}
+}
+
+func TestDialerWithDialerID(t *testing.T) {
+	d, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithDialerID("my-dialer"),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	if got := d.ID(); got != "my-dialer" {
+		t.Fatalf("want = %v, got = %v", "my-dialer", got)
+	}
+
+	_, err = NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithDialerID(""),
+	)
+	var cfgErr *errtype.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("want = %T, got = %v", cfgErr, err)
+	}
+}
+
+type dialCtxKey struct{}
+
+func TestDialerPassesCallerContextToDialFunc(t *testing.T) {
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	var (
+		gotValue    interface{}
+		gotDeadline time.Time
+	)
+	errDial := errors.New("dial failed")
+	d, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+		WithDialFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+			gotValue = ctx.Value(dialCtxKey{})
+			gotDeadline, _ = ctx.Deadline()
+			return nil, errDial
+		}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(
+		context.WithValue(context.Background(), dialCtxKey{}, "span"), deadline,
+	)
+	defer cancel()
+	_, err = d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if !errors.Is(err, errDial) {
+		t.Fatalf("want = %v, got = %v", errDial, err)
+	}
+	if gotValue != "span" {
+		t.Fatalf("want the dial func to observe the caller's value, got = %v", gotValue)
+	}
+	if !gotDeadline.Equal(deadline) {
+		t.Fatalf("want deadline = %v, got = %v", deadline, gotDeadline)
+	}
+}
+
+func TestDialerWithMaxConnLifetimeFromCert(t *testing.T) {
+	ctx := context.Background()
+	// Certificates carry expiry times with second precision.
+	expiry := time.Now().Add(2 * time.Second).Truncate(time.Second)
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(expiry),
+	)
+	closed := make(chan time.Time, 1)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		// Refresh only on Dial, as the certificate is within the refresh
+		// buffer.
+		WithLazyRefresh(),
+		WithMaxConnLifetimeFromCert(),
+		WithOnDisconnect(func(InstanceURI, time.Duration) {
+			closed <- time.Now()
+		}),
+	)
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case at := <-closed:
+		if at.Before(expiry) {
+			t.Fatalf("want the connection closed at %v, got closed at %v", expiry, at)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("want the connection closed once the certificate expired")
+	}
+	if err := conn.Close(); err == nil {
+		t.Fatal("want Close to fail on a connection that's already closed")
+	}
+}
+
+func TestDialerWithRefreshFailureBackoffErrors(t *testing.T) {
+	tcs := []struct {
+		min, max time.Duration
+	}{
+		{min: 0, max: time.Second},
+		{min: -time.Second, max: time.Second},
+		{min: time.Minute, max: time.Second},
+	}
+	for _, tc := range tcs {
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithRefreshFailureBackoff(tc.min, tc.max),
+		)
+		var cfgErr *errtype.ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("WithRefreshFailureBackoff(%v, %v): want ConfigError, got = %v", tc.min, tc.max, err)
+		}
+	}
+}
+
+func TestDialerReportsCacheHit(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	})
+
+	for n, want := range []bool{false, true} {
+		var res DialResult
+		conn, err := d.Dial(ctx,
+			"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+			WithDialResult(&res),
+		)
+		if err != nil {
+			t.Fatalf("expected Dial to succeed, but got error: %v", err)
+		}
+		conn.Close()
+		if res.CacheHit != want {
+			t.Fatalf("dial %d: want CacheHit = %v, got = %v", n, want, res.CacheHit)
+		}
+	}
+}
+
+func TestDialerWithInsecureSkipVerify(t *testing.T) {
+	t.Setenv(InsecureSkipVerifyEnv, "true")
+	ctx := context.Background()
+	// The server side proxy presents a self-signed certificate.
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithUntrustedServerCert(),
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	}, WithInsecureSkipVerify())
+
+	conn, err := d.Dial(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected ReadAll to succeed, got error %v", err)
+	}
+	if string(data) != "my-instance" {
+		t.Fatalf("expected known response from the server, but got %v", string(data))
+	}
+}
+
+func TestDialerWithInsecureSkipVerifyRequiresEnv(t *testing.T) {
+	for _, v := range []string{"", "1", "false"} {
+		t.Setenv(InsecureSkipVerifyEnv, v)
+		_, err := NewDialer(context.Background(),
+			WithTokenSource(stubTokenSource{}),
+			WithInsecureSkipVerify(),
+		)
+		var cfgErr *errtype.ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("%v=%q: want ConfigError, got = %v", InsecureSkipVerifyEnv, v, err)
+		}
+	}
+}
+
+func TestDialerWaitForRefresh(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// The forced refresh makes the second calls.
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	})
+
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	var cfgErr *errtype.ConfigError
+	if err := d.WaitForRefresh(ctx, uri); !errors.As(err, &cfgErr) {
+		t.Fatalf("before the instance is dialed, want = %T, got = %v", cfgErr, err)
+	}
+
+	if err := d.Warmup(ctx, uri); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	first := d.ReportHealth()[uri].LastRefresh
+	if err := d.ForceRefresh(uri); err != nil {
+		t.Fatalf("expected ForceRefresh to succeed, but got error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := d.WaitForRefresh(ctx, uri); err != nil {
+		t.Fatalf("expected WaitForRefresh to succeed, but got error: %v", err)
+	}
+	if got := d.ReportHealth()[uri].LastRefresh; !got.After(first) {
+		t.Fatalf("want a refresh after %v, got last refresh = %v", first, got)
+	}
+}
+
+func TestDialerWithDefaultCredentials(t *testing.T) {
+	path, scope := writeRecordingCredentialsFile(t, "adc-token")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	tcs := []struct {
+		desc   string
+		scopes []string
+		want   string
+	}{
+		{desc: "default scope", want: CloudPlatformScope},
+		{
+			desc:   "explicit scopes",
+			scopes: []string{"https://www.googleapis.com/auth/alloydb", "openid"},
+			want:   "https://www.googleapis.com/auth/alloydb openid",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			d, err := NewDialer(context.Background(), WithDefaultCredentials(tc.scopes...))
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+
+			tok, err := d.iamTokenSource.Token()
+			if err != nil {
+				t.Fatalf("failed to get token: %v", err)
+			}
+			if got, want := tok.AccessToken, "adc-token"; got != want {
+				t.Fatalf("token mismatch, want = %v, got = %v", want, got)
+			}
+			if got := scope(); got != tc.want {
+				t.Fatalf("scope mismatch, want = %v, got = %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDialerWithDefaultCredentialsNotFound(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	_, err := NewDialer(context.Background(), WithDefaultCredentials())
+	var cfgErr *errtype.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("want = %T, got = %v", cfgErr, err)
+	}
+}
+
+func TestDialerMaxOpenConns(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if _, err := d.MaxOpenConns(uri); err == nil {
+		t.Fatal("want an error before the instance is dialed, got nil")
+	}
+	dial := func() net.Conn {
+		conn, err := d.Dial(ctx, uri)
+		if err != nil {
+			t.Fatalf("expected Dial to succeed, but got error: %v", err)
+		}
+		return conn
+	}
+
+	// Open three connections, close two, then open one more.
+	conns := []net.Conn{dial(), dial(), dial()}
+	conns[0].Close()
+	conns[1].Close()
+	parsed, _ := alloydb.ParseInstURI(uri)
+	d.lock.RLock()
+	i := d.instances[parsed]
+	d.lock.RUnlock()
+	waitForOpenConns(t, i, 1)
+	conns = append(conns[2:], dial())
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	got, err := d.MaxOpenConns(uri)
+	if err != nil {
+		t.Fatalf("expected MaxOpenConns to succeed, but got error: %v", err)
+	}
+	if got != 3 {
+		t.Fatalf("want max open connections = 3, got = %v", got)
+	}
+}
+
+func TestDialerWithPreDialHook(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		WithDialFunc(func(context.Context, string, string) (net.Conn, error) {
+			t.Error("want the vetoed dial not to connect")
+			return nil, errors.New("unexpected dial")
+		}),
+	)
+
+	errOpen := errors.New("circuit open")
+	var got InstanceURI
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	_, err := d.Dial(ctx, uri, WithPreDialHook(func(_ context.Context, inst InstanceURI) error {
+		got = inst
+		return errOpen
+	}))
+	var dialErr *errtype.DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("want = %T, got = %v", dialErr, err)
+	}
+	if !errors.Is(err, errOpen) {
+		t.Fatalf("want error to wrap %v, got = %v", errOpen, err)
+	}
+	if got.URI() != uri {
+		t.Fatalf("want hook called with %v, got = %v", uri, got.URI())
+	}
+}
+
+func TestDialerWithIPFallback(t *testing.T) {
+	ctx := context.Background()
+	// The Admin API reports both addresses of the instance.
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr("10.0.0.1"),
+		mock.WithPublicIPAddr("127.0.0.1"),
+	)
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	},
+		WithDialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasPrefix(addr, "10.0.0.1:") {
+				return nil, errors.New("private IP is unreachable")
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}),
+	)
+
+	var res DialResult
+	conn, err := d.Dial(ctx,
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		WithIPFallback([]IPType{PrivateIP, PublicIP}),
+		WithDialResult(&res),
+	)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+
+	if res.IPType != "PUBLIC" || res.IPAddress != "127.0.0.1" {
+		t.Fatalf("want dial via PUBLIC 127.0.0.1, got = %v %v", res.IPType, res.IPAddress)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected ReadAll to succeed, got error %v", err)
+	}
+	if string(data) != "my-instance" {
+		t.Fatalf("expected known response from the server, but got %v", string(data))
+	}
+}
+
+// certTemplateRefresher issues a self-signed client certificate from a
+// template.
+type certTemplateRefresher struct {
+	tmpl *x509.Certificate
+}
+
+func (f certTemplateRefresher) Refresh(_ context.Context, _ InstanceURI, key *rsa.PrivateKey) (RefreshResult, error) {
+	der, err := x509.CreateCertificate(rand.Reader, f.tmpl, f.tmpl, &key.PublicKey, key)
+	if err != nil {
+		return RefreshResult{}, err
+	}
+	return RefreshResult{
+		IPAddrs:    map[IPType]string{PrivateIP: "127.0.0.1"},
+		ClientCert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		RootCAs:    x509.NewCertPool(),
+		Expiry:     f.tmpl.NotAfter,
+	}, nil
+}
+
+func TestDialerCertificateInfo(t *testing.T) {
+	ctx := context.Background()
+	// No Admin API requests are expected.
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	notBefore := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	notAfter := notBefore.Add(time.Hour)
+	spiffe, err := neturl.Parse("spiffe://example.com/client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+		WithRefresher(certTemplateRefresher{tmpl: &x509.Certificate{
+			SerialNumber:   big.NewInt(1),
+			Subject:        pkix.Name{CommonName: "client.alloydb", Organization: []string{"Google"}},
+			DNSNames:       []string{"client.alloydb"},
+			EmailAddresses: []string{"sa@my-project.iam.gserviceaccount.com"},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+			URIs:           []*neturl.URL{spiffe},
+			NotBefore:      notBefore,
+			NotAfter:       notAfter,
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	got, err := d.CertificateInfo(ctx,
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+	)
+	if err != nil {
+		t.Fatalf("expected CertificateInfo to succeed, but got error: %v", err)
+	}
+	want := CertificateInfo{
+		Subject:        "CN=client.alloydb,O=Google",
+		DNSNames:       []string{"client.alloydb"},
+		EmailAddresses: []string{"sa@my-project.iam.gserviceaccount.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1").To4()},
+		URIs:           []string{"spiffe://example.com/client"},
+		NotBefore:      notBefore,
+		NotAfter:       notAfter,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CertificateInfo mismatch\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestDialerCertificateInfoErrors(t *testing.T) {
+	ctx := context.Background()
+	d, err := NewDialer(ctx, WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	_, err = d.CertificateInfo(ctx, "bad-instance-name")
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when instance name is invalid, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialerWithStaticConnectionInfo(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer stop()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, pool, err := inst.ClientCert(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither credentials nor an Admin API endpoint are configured, as the
+	// Admin API is never called.
+	d, err := NewDialer(ctx, WithStaticConnectionInfo("127.0.0.1", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS13,
+	}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	conn, err := d.Dial(ctx, uri)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected ReadAll to succeed, got error %v", err)
+	}
+	if string(data) != "my-instance" {
+		t.Fatalf("expected known response from the server, but got %v", string(data))
+	}
+
+	_, err = d.EngineVersion(ctx, uri)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when connection info is static, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialerWithStaticConnectionInfoErrors(t *testing.T) {
+	tcs := []struct {
+		desc string
+		ip   string
+		conf *tls.Config
+	}{
+		{desc: "missing IP address", conf: &tls.Config{}},
+		{desc: "missing TLS config", ip: "127.0.0.1"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(),
+				WithStaticConnectionInfo(tc.ip, tc.conf),
+			)
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestApplicationNameParam(t *testing.T) {
+	tcs := []struct {
+		name string
+		want string
+	}{
+		{name: "my-app", want: "application_name='my-app'"},
+		{name: "my app", want: "application_name='my app'"},
+		{name: `it's\here`, want: `application_name='it\'s\\here'`},
+	}
+	for _, tc := range tcs {
+		if got := ApplicationNameParam(tc.name); got != tc.want {
+			t.Errorf("ApplicationNameParam(%q): want = %v, got = %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestDialerApplicationName(t *testing.T) {
+	d, err := NewDialer(context.Background(),
+		WithTokenSource(stubTokenSource{}),
+		WithDefaultDialOptions(WithApplicationName("my-app")),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	if got := d.ApplicationName(); got != "my-app" {
+		t.Fatalf("want = %q, got = %q", "my-app", got)
+	}
+}
+
+func TestDialerStats(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer stop()
+
+	r := &fakeRefresher{inst: inst}
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(r),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	uris := []string{
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-other-instance",
+	}
+	for _, uri := range uris {
+		conn, err := d.Dial(ctx, uri)
+		if err != nil {
+			t.Fatalf("expected Dial to succeed, but got error: %v", err)
+		}
+		defer conn.Close()
+	}
+	if _, err := d.Dial(ctx, "bad-instance-name"); err == nil {
+		t.Fatal("want Dial to fail with an invalid instance URI, got nil")
+	}
+
+	got := d.Stats()
+	want := DialerStats{
+		Instances:  2,
+		OpenConns:  3,
+		Dials:      4,
+		DialErrors: 1,
+		Refreshes:  2,
+	}
+	if got != want {
+		t.Fatalf("Stats mismatch, want = %+v, got = %+v", want, got)
+	}
+}
+
+// csrRecordingTransport records the CSR of each request for an ephemeral
+// certificate.
+type csrRecordingTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	csrs []string
+}
+
+func (c *csrRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, ":generateClientCertificate") {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		var body struct {
+			PemCsr string `json:"pemCsr"`
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.csrs = append(c.csrs, body.PemCsr)
+		c.mu.Unlock()
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	return c.base.RoundTrip(req)
+}
+
+func (c *csrRecordingTransport) CSRs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.csrs...)
+}
+
+func TestDialerWithCSRTemplate(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	rt := &csrRecordingTransport{base: mc.Transport}
+
+	var calls int32
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(&http.Client{Transport: rt}),
+		WithAdminAPIEndpoint(url),
+		WithCSRTemplate(func(tmpl *x509.CertificateRequest) {
+			atomic.AddInt32(&calls, 1)
+			tmpl.Subject = pkix.Name{CommonName: "my-app", Organization: []string{"My Org"}}
+			tmpl.DNSNames = []string{"my-app.example.com"}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	// The mock rejects a CSR that isn't signed with and for the Dialer's key.
+	err = d.Warmup(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("want CSR template called once, got = %v", got)
+	}
+	csrs := rt.CSRs()
+	if len(csrs) != 1 {
+		t.Fatalf("want 1 CSR sent, got = %v", len(csrs))
+	}
+	bl, _ := pem.Decode([]byte(csrs[0]))
+	if bl == nil {
+		t.Fatalf("want PEM encoded CSR, got = %q", csrs[0])
+	}
+	csr, err := x509.ParseCertificateRequest(bl.Bytes)
+	if err != nil {
+		t.Fatalf("expected ParseCertificateRequest to succeed, but got error: %v", err)
+	}
+	if got, want := csr.Subject.String(), "CN=my-app,O=My Org"; got != want {
+		t.Fatalf("CSR subject: want = %v, got = %v", want, got)
+	}
+	if got, want := csr.DNSNames, []string{"my-app.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("CSR DNS names: want = %v, got = %v", want, got)
+	}
+}
+
+func TestDialerWithCSRTemplateErrors(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithCSRTemplate(nil),
+	)
+	var cfgErr *errtype.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("when the CSR template func is nil, want = %T, got = %v", cfgErr, err)
+	}
+
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDialer(t, inst, []*mock.Request{
+		mock.InstanceGetSuccess(inst, 1),
+	},
+		WithCSRTemplate(func(tmpl *x509.CertificateRequest) {
+			tmpl.PublicKey = &otherKey.PublicKey
+		}),
+	)
+
+	err = d.Warmup(ctx, "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	var refreshErr *errtype.RefreshError
+	if !errors.As(err, &refreshErr) {
+		t.Fatalf("when the CSR template replaces the public key, want = %T, got = %v", refreshErr, err)
+	}
+}
+
+func TestDialerDialContext(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer stop()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(&fakeRefresher{inst: inst}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	// The standard shape, e.g., of net.Dialer or proxy.ContextDialer.
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	} = d
+
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	for _, addr := range []string{uri, net.JoinHostPort(uri, "5432")} {
+		t.Run(addr, func(t *testing.T) {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				t.Fatalf("expected DialContext to succeed, but got error: %v", err)
+			}
+			defer conn.Close()
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("expected ReadAll to succeed, got error %v", err)
+			}
+			if string(data) != "my-instance" {
+				t.Fatalf("expected known response from the server, but got %v", string(data))
+			}
+		})
+	}
+
+	_, err = dialer.DialContext(ctx, "udp", uri)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when network is udp, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDialerSubscribe(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(&fakeRefresher{inst: inst}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	events, unsubscribe := d.Subscribe()
+	uri := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if err := d.Warmup(ctx, uri); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+
+	want := []RefreshEventType{RefreshScheduled, RefreshStarted, RefreshSucceeded, RefreshScheduled}
+	var got []RefreshEvent
+	for len(got) < len(want) {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got = %+v", got)
+		}
+	}
+	for n, e := range got {
+		if e.Type != want[n] || e.Instance != uri {
+			t.Fatalf("event %d: want %v for %v, got = %+v", n, want[n], uri, e)
+		}
+		if e.Time.IsZero() {
+			t.Fatalf("event %d: want timestamp, got = %+v", n, e)
+		}
+	}
+	// The certificate is valid for an hour, so the next refresh is
+	// scheduled well into the future.
+	if next := got[3].NextRefresh; next.Before(time.Now().Add(10 * time.Minute)) {
+		t.Fatalf("want next refresh at least 10 minutes out, got = %v", next)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatal("want channel closed after unsubscribing")
+	}
+}
+
+func TestDialerForceRefreshAllStopsPendingRefreshes(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	uris := []string{
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-other-instance",
+	}
+	pending := func(d *Dialer) int {
+		d.lock.RLock()
+		defer d.lock.RUnlock()
+		return len(d.forcedRefreshes)
+	}
+
+	tcs := []struct {
+		desc string
+		stop func(d *Dialer) error
+	}{
+		{
+			desc: "with RemoveInstance",
+			stop: func(d *Dialer) error { return d.RemoveInstance(uris[1]) },
+		},
+		{
+			desc: "with Close",
+			stop: func(d *Dialer) error { return d.Close() },
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			// With a budget of two refreshes a minute, the second refresh
+			// is forced 30s after the first.
+			d, err := NewDialer(ctx,
+				WithTokenSource(stubTokenSource{}),
+				WithRefresher(&fakeRefresher{inst: inst}),
+				WithRefreshBudget(2),
+			)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+			for _, uri := range uris {
+				if err := d.Warmup(ctx, uri); err != nil {
+					t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+				}
+			}
+
+			d.ForceRefreshAll()
+			if got := pending(d); got != 1 {
+				t.Fatalf("want 1 pending forced refresh, got = %v", got)
+			}
+			if err := tc.stop(d); err != nil {
+				t.Fatalf("want no error, got = %v", err)
+			}
+			if got := pending(d); got != 0 {
+				t.Fatalf("want no pending forced refreshes, got = %v", got)
+			}
+		})
+	}
+}
+
+func TestDialerForceRefreshAll(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(&fakeRefresher{inst: inst}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	uris := []string{
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-other-instance",
+	}
+	for _, uri := range uris {
+		if err := d.Warmup(ctx, uri); err != nil {
+			t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+		}
+	}
+
+	events, unsubscribe := d.Subscribe()
+	defer unsubscribe()
+	d.ForceRefreshAll()
+
+	// Each instance schedules an immediate refresh that succeeds.
+	scheduled := make(map[string]bool)
+	succeeded := make(map[string]bool)
+	for len(succeeded) < len(uris) {
+		select {
+		case e := <-events:
+			switch e.Type {
+			case RefreshScheduled:
+				if !succeeded[e.Instance] && e.NextRefresh.After(e.Time.Add(time.Second)) {
+					t.Fatalf("want immediate refresh of %v, got = %+v", e.Instance, e)
+				}
+				scheduled[e.Instance] = true
+			case RefreshSucceeded:
+				succeeded[e.Instance] = true
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for forced refreshes, succeeded = %v", succeeded)
+		}
+	}
+	for _, uri := range uris {
+		if !scheduled[uri] || !succeeded[uri] {
+			t.Fatalf("want forced refresh of %v, scheduled = %v, succeeded = %v", uri, scheduled, succeeded)
+		}
+	}
+}
+
+func TestDialerWithMinTLSVersion(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	parsed, _ := alloydb.ParseInstURI(instURI)
+	suites := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+
+	tcs := []struct {
+		desc        string
+		opts        []Option
+		wantVersion uint16
+		wantSuites  []uint16
+	}{
+		{
+			desc:        "by default",
+			wantVersion: tls.VersionTLS13,
+		},
+		{
+			desc: "with TLS 1.2 and cipher suites",
+			opts: []Option{
+				WithMinTLSVersion(tls.VersionTLS12),
+				WithCipherSuites(suites),
+			},
+			wantVersion: tls.VersionTLS12,
+			wantSuites:  suites,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := append([]Option{
+				WithTokenSource(stubTokenSource{}),
+				WithRefresher(&fakeRefresher{inst: inst}),
+			}, tc.opts...)
+			d, err := NewDialer(ctx, opts...)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+			if err := d.Warmup(ctx, instURI); err != nil {
+				t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+			}
+
+			d.lock.RLock()
+			i := d.instances[parsed]
+			d.lock.RUnlock()
+			_, tlsCfg, err := i.ConnectInfo(ctx, alloydb.PrivateIP)
+			if err != nil {
+				t.Fatalf("expected ConnectInfo to succeed, but got error: %v", err)
+			}
+			if got := tlsCfg.MinVersion; got != tc.wantVersion {
+				t.Fatalf("MinVersion mismatch, want = %v, got = %v",
+					tls.VersionName(tc.wantVersion), tls.VersionName(got))
+			}
+			if got := tlsCfg.CipherSuites; !reflect.DeepEqual(got, tc.wantSuites) {
+				t.Fatalf("CipherSuites mismatch, want = %v, got = %v", tc.wantSuites, got)
+			}
+		})
+	}
+}
+
+func TestDialerWithMinTLSVersionErrors(t *testing.T) {
+	tcs := []struct {
+		desc string
+		opt  Option
+	}{
+		{
+			desc: "with TLS 1.1",
+			opt:  WithMinTLSVersion(tls.VersionTLS11),
+		},
+		{
+			desc: "with no cipher suites",
+			opt:  WithCipherSuites(nil),
+		},
+		{
+			desc: "with an insecure cipher suite",
+			opt:  WithCipherSuites([]uint16{tls.TLS_RSA_WITH_RC4_128_SHA}),
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewDialer(context.Background(),
+				WithTokenSource(stubTokenSource{}),
+				tc.opt,
+			)
+			var cfgErr *errtype.ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("want = %T, got = %v", cfgErr, err)
+			}
+		})
+	}
+}
+
+func TestDialerProbe(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer stop()
+
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(&fakeRefresher{inst: inst}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if err := d.Probe(ctx, instURI); err != nil {
+		t.Fatalf("expected Probe to succeed, but got error: %v", err)
+	}
+	got := d.Stats()
+	if got.OpenConns != 0 || got.Dials != 1 || got.DialErrors != 0 {
+		t.Fatalf("want 1 dial and no open connections after Probe, got = %+v", got)
+	}
+}
+
+func TestDialerProbeErrors(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithRefresher(&fakeRefresher{inst: inst}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	var cfgErr *errtype.ConfigError
+	if err := d.Probe(ctx, "bad-instance-name"); !errors.As(err, &cfgErr) {
+		t.Fatalf("with an invalid instance URI, want = %T, got = %v", cfgErr, err)
+	}
+
+	// No server side proxy is listening, so the dial fails.
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	var dialErr *errtype.DialError
+	if err := d.Probe(ctx, instURI); !errors.As(err, &dialErr) {
+		t.Fatalf("without a server side proxy, want = %T, got = %v", dialErr, err)
+	}
+	if got := d.Stats().OpenConns; got != 0 {
+		t.Fatalf("want no open connections after a failed Probe, got = %v", got)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgxv5
+
+import (
+	"context"
+	"net"
+
+	"cloud.google.com/go/alloydbconn"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DialConfig configures the connections created by a DialFunc.
+type DialConfig struct {
+	// IPType is the type of IP address used to connect to the instance,
+	// i.e., "private" or "public". Defaults to the Dialer's default
+	// IP type.
+	IPType string
+	// Options are any additional DialOptions used for each connection.
+	Options []alloydbconn.DialOption
+}
+
+// DialFunc returns a pgconn.DialFunc that connects to AlloyDB instances with
+// the provided Dialer. The function is meant to be used as the DialFunc of a
+// pgx.ConnConfig (or pgxpool.Config.ConnConfig) whose host is set to the
+// instance URI. For example:
+//
+//	config, err := pgxpool.ParseConfig(
+//		"host=projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE> user=myuser dbname=mydb sslmode=disable",
+//	)
+//	// handle error
+//	config.ConnConfig.DialFunc, err = pgxv5.DialFunc(d, pgxv5.DialConfig{})
+//	// handle error
+//
+// IAM authentication is configured on the Dialer with
+// alloydbconn.WithIAMAuthN.
+func DialFunc(d *alloydbconn.Dialer, cfg DialConfig) (pgconn.DialFunc, error) {
+	opts := append([]alloydbconn.DialOption{}, cfg.Options...)
+	if cfg.IPType != "" {
+		opt, err := ipTypeOption(cfg.IPType, "n/a")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		// pgx joins the host (i.e., the instance URI) with the port.
+		instance, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		return d.Dial(ctx, instance, opts...)
+	}, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgxv5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"cloud.google.com/go/alloydbconn"
+	"cloud.google.com/go/alloydbconn/errtype"
+	"cloud.google.com/go/alloydbconn/internal/mock"
+	"golang.org/x/oauth2"
+)
+
+type stubTokenSource struct{}
+
+func (stubTokenSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{}, nil
+}
+
+func TestDialFunc(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	// Listen on a random port so the test doesn't conflict with tests
+	// of other packages using the default server proxy port.
+	stop, proxyAddr := mock.StartServerProxyAt(t, inst, "127.0.0.1:0")
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	d, err := alloydbconn.NewDialer(ctx,
+		alloydbconn.WithTokenSource(stubTokenSource{}),
+		alloydbconn.WithHTTPClient(mc),
+		alloydbconn.WithAdminAPIEndpoint(url),
+		alloydbconn.WithDialFunc(func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, proxyAddr)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	f, err := DialFunc(d, DialConfig{IPType: "private"})
+	if err != nil {
+		t.Fatalf("expected DialFunc to succeed, but got error: %v", err)
+	}
+	// pgx passes the host and port as the address.
+	addr := net.JoinHostPort(
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		"5432",
+	)
+	conn, err := f(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatalf("expected dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected ReadAll to succeed, got error %v", err)
+	}
+	if string(data) != "my-instance" {
+		t.Fatalf("expected known response from the server, but got %v", string(data))
+	}
+}
+
+func TestDialFuncErrors(t *testing.T) {
+	d, err := alloydbconn.NewDialer(context.Background(),
+		alloydbconn.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	_, err = DialFunc(d, DialConfig{IPType: "bogus"})
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("want = %T, got = %v", wantErr, err)
+	}
+}
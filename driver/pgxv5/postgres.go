@@ -20,10 +20,14 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 
 	"cloud.google.com/go/alloydbconn"
+	"cloud.google.com/go/alloydbconn/errtype"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/stdlib"
 )
@@ -46,6 +50,59 @@ func RegisterDriver(name string, opts ...alloydbconn.Option) (func() error, erro
 	return func() error { return d.Close() }, nil
 }
 
+// ipTypeParam is the connection string parameter that selects the type of IP
+// address used to connect to the instance, i.e., private (the default) or
+// public.
+const ipTypeParam = "alloydb_ip_type"
+
+// ipTypeOption returns the DialOption for the provided ipTypeParam value.
+func ipTypeOption(v, instConnName string) (alloydbconn.DialOption, error) {
+	switch strings.ToLower(v) {
+	case "private":
+		return alloydbconn.WithPrivateIP(), nil
+	case "public":
+		return alloydbconn.WithPublicIP(), nil
+	default:
+		return nil, errtype.NewConfigError(
+			fmt.Sprintf("invalid %s %q, expected private or public", ipTypeParam, v),
+			instConnName,
+		)
+	}
+}
+
+// iamAuthNParam is the connection string parameter that enables (true) or
+// disables (false) automatic IAM database authentication for the connection,
+// in place of the Dialer's configuration.
+const iamAuthNParam = "alloydb_iam_authn"
+
+// iamAuthN returns whether the provided iamAuthNParam value enables IAM
+// authentication.
+func iamAuthN(v, instConnName string) (bool, error) {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errtype.NewConfigError(
+			fmt.Sprintf("invalid %s %q, expected true or false", iamAuthNParam, v),
+			instConnName,
+		)
+	}
+	return b, nil
+}
+
+// applicationNameParam is the Postgres parameter that names the application
+// in pg_stat_activity.
+const applicationNameParam = "application_name"
+
+// setApplicationName sets the application_name of the runtime params to name,
+// unless name is empty or the connection string already set it.
+func setApplicationName(params map[string]string, name string) {
+	if name == "" {
+		return
+	}
+	if _, ok := params[applicationNameParam]; !ok {
+		params[applicationNameParam] = name
+	}
+}
+
 type pgDriver struct {
 	d  *alloydbconn.Dialer
 	mu sync.RWMutex
@@ -58,6 +115,13 @@ type pgDriver struct {
 // should be specified in the host field. For example:
 //
 // "host=projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE> user=myuser password=mypass"
+//
+// The type of IP address may be selected with the alloydb_ip_type parameter,
+// e.g., alloydb_ip_type=public. IAM authentication is configured on the Dialer
+// with alloydbconn.WithIAMAuthN when registering the driver, or for the
+// connection with the alloydb_iam_authn parameter, e.g., alloydb_iam_authn=true.
+// Unless the connection string sets application_name, the one configured
+// on the Dialer with alloydbconn.WithApplicationName is used.
 func (p *pgDriver) Open(name string) (driver.Conn, error) {
 	dbURI, err := p.dbURI(name)
 	if err != nil {
@@ -83,8 +147,37 @@ func (p *pgDriver) dbURI(name string) (string, error) {
 	}
 	instConnName := config.Config.Host // Extract instance connection name
 	config.Config.Host = "localhost"   // Replace it with a default value
+	setApplicationName(config.Config.RuntimeParams, p.d.ApplicationName())
+	var dialOpts []alloydbconn.DialOption
+	useIAMAuthN := p.d.IAMAuthN()
+	if v, ok := config.Config.RuntimeParams[iamAuthNParam]; ok {
+		delete(config.Config.RuntimeParams, iamAuthNParam)
+		useIAMAuthN, err = iamAuthN(v, instConnName)
+		if err != nil {
+			return "", err
+		}
+		dialOpts = append(dialOpts, alloydbconn.WithDialIAMAuthN(useIAMAuthN))
+	}
+	// A static password would be silently ignored in favor of the OAuth2
+	// token, so refuse the ambiguous configuration.
+	if useIAMAuthN && config.Config.Password != "" {
+		return "", errtype.NewConfigError(
+			"a database password cannot be used with IAM authentication",
+			instConnName,
+		)
+	}
+	if v, ok := config.Config.RuntimeParams[ipTypeParam]; ok {
+		// The parameter is not a Postgres setting, so don't send it to
+		// the server.
+		delete(config.Config.RuntimeParams, ipTypeParam)
+		opt, err := ipTypeOption(v, instConnName)
+		if err != nil {
+			return "", err
+		}
+		dialOpts = append(dialOpts, opt)
+	}
 	config.DialFunc = func(ctx context.Context, _, _ string) (net.Conn, error) {
-		return p.d.Dial(ctx, instConnName)
+		return p.d.Dial(ctx, instConnName, dialOpts...)
 	}
 
 	dbURI = stdlib.RegisterConnConfig(config)
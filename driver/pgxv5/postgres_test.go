@@ -0,0 +1,151 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgxv5
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/alloydbconn"
+	"cloud.google.com/go/alloydbconn/errtype"
+)
+
+func TestIPTypeOption(t *testing.T) {
+	for _, v := range []string{"private", "public", "PUBLIC"} {
+		t.Run(v, func(t *testing.T) {
+			opt, err := ipTypeOption(v, "my-instance")
+			if err != nil {
+				t.Fatalf("want no error, got = %v", err)
+			}
+			if opt == nil {
+				t.Fatal("want DialOption, got nil")
+			}
+		})
+	}
+}
+
+func TestIPTypeOptionErrors(t *testing.T) {
+	_, err := ipTypeOption("bogus", "my-instance")
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestSetApplicationName(t *testing.T) {
+	tcs := []struct {
+		desc   string
+		params map[string]string
+		name   string
+		want   string
+	}{
+		{
+			desc:   "Dialer's name is used",
+			params: map[string]string{},
+			name:   "my-app",
+			want:   "my-app",
+		},
+		{
+			desc:   "connection string takes precedence",
+			params: map[string]string{"application_name": "from-dsn"},
+			name:   "my-app",
+			want:   "from-dsn",
+		},
+		{
+			desc:   "no name configured",
+			params: map[string]string{},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			setApplicationName(tc.params, tc.name)
+			if got := tc.params["application_name"]; got != tc.want {
+				t.Fatalf("want = %q, got = %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDBURIWithIAMAuthN(t *testing.T) {
+	d, err := alloydbconn.NewDialer(context.Background(),
+		alloydbconn.WithTokenSource(stubTokenSource{}),
+		alloydbconn.WithIAMAuthN(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	p := &pgDriver{d: d, dbURIs: make(map[string]string)}
+	inst := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+
+	if _, err := p.dbURI("host=" + inst + " user=my-sa@my-project.iam"); err != nil {
+		t.Fatalf("want no error without a password, got = %v", err)
+	}
+	_, err = p.dbURI("host=" + inst + " user=my-sa@my-project.iam password=my-pass")
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestDBURIWithIAMAuthNParam(t *testing.T) {
+	inst := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	tcs := []struct {
+		desc    string
+		opts    []alloydbconn.Option
+		dsn     string
+		wantErr bool
+	}{
+		{
+			desc:    "enabled with a password",
+			dsn:     "host=" + inst + " user=my-user password=my-pass alloydb_iam_authn=true",
+			wantErr: true,
+		},
+		{
+			desc: "disabled with a password",
+			opts: []alloydbconn.Option{alloydbconn.WithIAMAuthN()},
+			dsn:  "host=" + inst + " user=my-user password=my-pass alloydb_iam_authn=false",
+		},
+		{
+			desc:    "invalid value",
+			dsn:     "host=" + inst + " user=my-user alloydb_iam_authn=bogus",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := append([]alloydbconn.Option{alloydbconn.WithTokenSource(stubTokenSource{})}, tc.opts...)
+			d, err := alloydbconn.NewDialer(context.Background(), opts...)
+			if err != nil {
+				t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+			}
+			defer d.Close()
+			p := &pgDriver{d: d, dbURIs: make(map[string]string)}
+
+			_, err = p.dbURI(tc.dsn)
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatalf("want no error, got = %v", err)
+				}
+				return
+			}
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+		})
+	}
+}
@@ -92,3 +92,56 @@ func (e *DialError) Error() string {
 }
 
 func (e *DialError) Unwrap() error { return e.Err }
+
+// NewTLSError initializes a TLSError.
+func NewTLSError(msg, cn string, err error) *TLSError {
+	return &TLSError{
+		genericError: &genericError{Message: msg, ConnName: cn},
+		Err:          err,
+	}
+}
+
+// TLSError means that the server's certificate could not be verified during
+// the TLS handshake (e.g., the certificate is not signed by the instance's
+// CA, or has expired or is not yet valid because of clock skew on the
+// client).
+type TLSError struct {
+	*genericError
+	// Err is the underlying verification error.
+	Err error
+}
+
+func (e *TLSError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("TLS error: %v", e.genericError)
+	}
+	return fmt.Sprintf("TLS error: %v: %v", e.genericError, e.Err)
+}
+
+func (e *TLSError) Unwrap() error { return e.Err }
+
+// NewNotFoundError initializes a NotFoundError.
+func NewNotFoundError(msg, cn string, err error) *NotFoundError {
+	return &NotFoundError{
+		genericError: &genericError{Message: msg, ConnName: cn},
+		Err:          err,
+	}
+}
+
+// NotFoundError means that the AlloyDB Admin API reported that the instance
+// (or its cluster) does not exist. Unlike a RefreshError, retrying is not
+// expected to succeed unless the instance is created.
+type NotFoundError struct {
+	*genericError
+	// Err is the underlying error and may be nil.
+	Err error
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("Not found error: %v", e.genericError)
+	}
+	return fmt.Sprintf("Not found error: %v: %v", e.genericError, e.Err)
+}
+
+func (e *NotFoundError) Unwrap() error { return e.Err }
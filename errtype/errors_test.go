@@ -60,6 +60,26 @@ func TestErrorFormatting(t *testing.T) {
 			),
 			want: "Dial error: message (instance URI = \"proj/reg/inst\"): inner-error",
 		},
+		{
+			desc: "TLS error without inner error",
+			err:  errtype.NewTLSError("message", "proj/reg/inst", nil),
+			want: "TLS error: message (instance URI = \"proj/reg/inst\")",
+		},
+		{
+			desc: "TLS error with inner error",
+			err:  errtype.NewTLSError("message", "proj/reg/inst", errors.New("inner-error")),
+			want: "TLS error: message (instance URI = \"proj/reg/inst\"): inner-error",
+		},
+		{
+			desc: "not found error without inner error",
+			err:  errtype.NewNotFoundError("message", "proj/reg/inst", nil),
+			want: "Not found error: message (instance URI = \"proj/reg/inst\")",
+		},
+		{
+			desc: "not found error with inner error",
+			err:  errtype.NewNotFoundError("message", "proj/reg/inst", errors.New("inner-error")),
+			want: "Not found error: message (instance URI = \"proj/reg/inst\"): inner-error",
+		},
 	}
 
 	for _, c := range tc {
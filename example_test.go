@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alloydbconn_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"cloud.google.com/go/alloydbconn"
+)
+
+// pipeConnector is a fake Connector that connects to an in-memory server
+// instead of an AlloyDB instance.
+type pipeConnector struct {
+	serve func(conn net.Conn)
+}
+
+func (c pipeConnector) Dial(_ context.Context, _ string, _ ...alloydbconn.DialOption) (net.Conn, error) {
+	client, server := net.Pipe()
+	go c.serve(server)
+	return client, nil
+}
+
+func (pipeConnector) Close() error { return nil }
+
+// greet is the code under test. It depends on a Connector, so tests can
+// provide a fake in place of a *alloydbconn.Dialer.
+func greet(ctx context.Context, c alloydbconn.Connector, instance string) (string, error) {
+	conn, err := c.Dial(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// This example swaps in a fake Connector, backed by a net.Pipe, for unit
+// testing code that connects to AlloyDB. In production, pass the
+// *alloydbconn.Dialer returned by NewDialer instead.
+func ExampleConnector() {
+	var c alloydbconn.Connector = pipeConnector{
+		serve: func(conn net.Conn) {
+			defer conn.Close()
+			conn.Write([]byte("hello from the fake instance"))
+		},
+	}
+	defer c.Close()
+
+	got, err := greet(context.Background(), c,
+		"projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(got)
+	// Output: hello from the fake instance
+}
@@ -20,38 +20,61 @@ import (
 	"crypto/tls"
 	"fmt"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	alloydbadmin "cloud.google.com/go/alloydb/apiv1beta"
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
+	"cloud.google.com/go/alloydbconn/debug"
 	"cloud.google.com/go/alloydbconn/errtype"
+	"cloud.google.com/go/alloydbconn/internal/trace"
 	"golang.org/x/time/rate"
 )
 
 const (
-	// the refresh buffer is the amount of time before a refresh cycle's result
-	// expires that a new refresh operation begins.
+	// the refresh buffer is the default amount of time before a refresh
+	// cycle's result expires that a new refresh operation begins.
 	refreshBuffer = 4 * time.Minute
 
-	// refreshInterval is the amount of time between refresh attempts as
-	// enforced by the rate limiter.
-	refreshInterval = 30 * time.Second
+	// CertLifetime is the lifetime of the ephemeral client certificates
+	// issued by the AlloyDB Admin API. A refresh buffer must be smaller than
+	// this value.
+	CertLifetime = time.Hour
+
+	// RefreshInterval is the default amount of time between refresh
+	// attempts as enforced by the rate limiter.
+	RefreshInterval = 30 * time.Second
 
 	// RefreshTimeout is the maximum amount of time to wait for a refresh
 	// cycle to complete. This value should be greater than the
-	// refreshInterval.
+	// RefreshInterval.
 	RefreshTimeout = 60 * time.Second
 
-	// refreshBurst is the initial burst allowed by the rate limiter.
-	refreshBurst = 2
+	// RefreshBurst is the default burst allowed by the rate limiter.
+	RefreshBurst = 2
+
+	// RefreshInProgress is returned by CurrentRefreshAge when no refresh
+	// has completed yet.
+	RefreshInProgress time.Duration = -1
+
+	// clockSkewRefreshDelay is the time until the next refresh when the
+	// certificate of a successful refresh is already within the refresh
+	// buffer according to the local clock, which is likely ahead of the
+	// server's. Refreshing sooner would only return another such certificate.
+	clockSkewRefreshDelay = time.Minute
 )
 
 var (
 	// Instance URI is in the format:
-	// '/projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>'
-	// Additionally, we have to support legacy "domain-scoped" projects
-	// (e.g. "google.com:PROJECT")
-	instURIRegex = regexp.MustCompile("projects/([^:]+(:[^:]+)?)/locations/([^:]+)/clusters/([^:]+)/instances/([^:]+)")
+	// 'projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>'
+	// with an optional leading slash. Additionally, we have to support legacy
+	// "domain-scoped" projects (e.g. "google.com:PROJECT")
+	instURIRegex = regexp.MustCompile("^/?projects/([^:/]+(:[^:/]+)?)/locations/([^:/]+)/clusters/([^:/]+)/instances/([^:/]+)$")
+	// The short form of an instance URI is in the format:
+	// '<PROJECT>:<REGION>:<CLUSTER>:<INSTANCE>'
+	// where the project may also be domain-scoped.
+	shortInstURIRegex = regexp.MustCompile("^([^:/]+(:[^:/]+)?):([^:/]+):([^:/]+):([^:/]+)$")
 )
 
 // InstanceURI represents an AlloyDB instance.
@@ -62,20 +85,49 @@ type InstanceURI struct {
 	name    string
 }
 
+// String returns the instance URI in its canonical form, as URI does, so that
+// the instance is identified the same way in keys, errors, logs and metrics.
 func (i *InstanceURI) String() string {
-	return fmt.Sprintf("%s/%s/%s/%s", i.project, i.region, i.cluster, i.name)
+	return i.URI()
+}
+
+// URI returns the instance URI in its canonical form, i.e.,
+// projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>
+// without a leading slash.
+func (i *InstanceURI) URI() string {
+	return fmt.Sprintf("projects/%s/locations/%s/clusters/%s/instances/%s",
+		i.project, i.region, i.cluster, i.name)
+}
+
+// Project returns the project ID, which may be domain-scoped (e.g.,
+// google.com:my-project).
+func (i *InstanceURI) Project() string {
+	return i.project
+}
+
+// Region returns the region (e.g., us-central1).
+func (i *InstanceURI) Region() string {
+	return i.region
 }
 
-// ParseInstURI initializes a new InstanceURI struct.
+// Cluster returns the cluster ID.
+func (i *InstanceURI) Cluster() string {
+	return i.cluster
+}
+
+// Name returns the instance ID.
+func (i *InstanceURI) Name() string {
+	return i.name
+}
+
+// ParseInstURI initializes a new InstanceURI struct. Both the full form
+// projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>
+// and the short form <PROJECT>:<REGION>:<CLUSTER>:<INSTANCE> are supported.
 func ParseInstURI(cn string) (InstanceURI, error) {
 	b := []byte(cn)
 	m := instURIRegex.FindSubmatch(b)
 	if m == nil {
-		err := errtype.NewConfigError(
-			"invalid instance URI, expected projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>",
-			cn,
-		)
-		return InstanceURI{}, err
+		return parseShortInstURI(cn)
 	}
 
 	c := InstanceURI{
@@ -87,6 +139,105 @@ func ParseInstURI(cn string) (InstanceURI, error) {
 	return c, nil
 }
 
+// parseShortInstURI parses an instance URI in the short, colon-delimited form.
+func parseShortInstURI(cn string) (InstanceURI, error) {
+	m := shortInstURIRegex.FindStringSubmatch(cn)
+	if m == nil {
+		err := errtype.NewConfigError(
+			"invalid instance URI, expected projects/<PROJECT>/locations/<REGION>/clusters/<CLUSTER>/instances/<INSTANCE>"+
+				" or <PROJECT>:<REGION>:<CLUSTER>:<INSTANCE>",
+			cn,
+		)
+		return InstanceURI{}, err
+	}
+	// With five components, the first two must form a domain-scoped
+	// project (e.g., google.com:PROJECT). Otherwise, it's not clear which
+	// component is which.
+	if m[2] != "" && !strings.Contains(m[1], ".") {
+		err := errtype.NewConfigError(
+			"ambiguous instance URI, only a domain-scoped project (e.g., google.com:PROJECT) may contain a colon",
+			cn,
+		)
+		return InstanceURI{}, err
+	}
+	return InstanceURI{
+		project: m[1],
+		region:  m[3],
+		cluster: m[4],
+		name:    m[5],
+	}, nil
+}
+
+// clock abstracts the passage of time for the refresh cycle, so the cycle can
+// be tested without real sleeps.
+type clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after duration d.
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// timer is a scheduled call that can be stopped before it runs.
+type timer interface {
+	// Stop prevents the call from running. It returns false if the call
+	// has already run or been stopped.
+	Stop() bool
+}
+
+// realClock is the clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
+
+// Health describes the state of an instance's cached connection info.
+type Health struct {
+	// LastRefresh is the time of the most recent successful refresh, or the
+	// zero time if no refresh has succeeded.
+	LastRefresh time.Time
+	// CertExpiry is the expiration time of the client certificate currently
+	// used for connections, or the zero time if there is none.
+	CertExpiry time.Time
+	// LastErr is the error of the most recent refresh, or nil if it
+	// succeeded.
+	LastErr error
+	// OpenConns is the number of open connections to the instance.
+	OpenConns uint64
+}
+
+// RefreshEventType identifies a stage of the refresh of an instance's
+// connection info.
+type RefreshEventType string
+
+const (
+	// RefreshScheduled reports that a refresh was scheduled.
+	RefreshScheduled RefreshEventType = "scheduled"
+	// RefreshStarted reports that a refresh started.
+	RefreshStarted RefreshEventType = "started"
+	// RefreshSucceeded reports that a refresh succeeded.
+	RefreshSucceeded RefreshEventType = "succeeded"
+	// RefreshFailed reports that a refresh failed.
+	RefreshFailed RefreshEventType = "failed"
+)
+
+// RefreshEvent describes a stage of the refresh of an instance's connection
+// info.
+type RefreshEvent struct {
+	// Instance is the URI of the instance (see InstanceURI.URI).
+	Instance string
+	// Type is the stage of the refresh.
+	Type RefreshEventType
+	// Time is when the stage was reached.
+	Time time.Time
+	// NextRefresh is when a scheduled refresh starts. It's only set for
+	// RefreshScheduled.
+	NextRefresh time.Time
+	// Err is the error of a failed refresh. It's only set for RefreshFailed.
+	Err error
+}
+
 // refreshOperation is a pending result of a refresh operation of data used to
 // connect securely. It should only be initialized by the Instance struct as
 // part of a refresh cycle.
@@ -95,9 +246,18 @@ type refreshOperation struct {
 	err    error
 
 	// timer that triggers refresh, can be used to cancel.
-	timer *time.Timer
+	timer timer
 	// indicates the struct is ready to read from
 	ready chan struct{}
+	// readyAt is the time the operation completed. It is set before ready
+	// is closed.
+	readyAt time.Time
+	// applied is closed once the Instance has been updated with the result
+	// of the operation, i.e., after ready, or once the operation is canceled.
+	applied chan struct{}
+	// canceled reports whether the operation was canceled before it
+	// started. It is set before applied is closed.
+	canceled bool
 }
 
 // Cancel prevents the instanceInfo from starting, if it hasn't already
@@ -109,13 +269,13 @@ func (r *refreshOperation) cancel() bool {
 
 // IsValid returns true if this result is complete, successful, and is still
 // valid.
-func (r *refreshOperation) isValid() bool {
+func (r *refreshOperation) isValid(now time.Time) bool {
 	// verify the result has finished running
 	select {
 	default:
 		return false
 	case <-r.ready:
-		if r.err != nil || time.Now().After(r.result.expiry) {
+		if r.err != nil || now.After(r.result.expiry) {
 			return false
 		}
 		return true
@@ -123,14 +283,18 @@ func (r *refreshOperation) isValid() bool {
 }
 
 // Instance manages the information used to connect to the AlloyDB instance by
-// periodically calling the AlloyDB Admin API. It automatically refreshes the
-// required information approximately 4 minutes before the previous certificate
-// expires (every ~56 minutes).
+// periodically calling the AlloyDB Admin API. By default, it automatically
+// refreshes the required information approximately 4 minutes before the
+// previous certificate expires (every ~56 minutes).
 type Instance struct {
 	// OpenConns is the number of open connections to the instance.
 	openConns uint64
+	// maxOpenConns is the highest number of open connections to the
+	// instance.
+	maxOpenConns uint64
 
 	instanceURI InstanceURI
+	logger      debug.Logger
 	key         *rsa.PrivateKey
 	// refreshTimeout sets the maximum duration a refresh cycle can run
 	// for.
@@ -138,6 +302,25 @@ type Instance struct {
 	// l controls the rate at which refresh cycles are run.
 	l *rate.Limiter
 	r refresher
+	// errHandler, if set, is called whenever a background refresh fails.
+	errHandler func(instance string, err error)
+	// onEvent, if set, is called at each stage of a refresh.
+	onEvent func(RefreshEvent)
+	// refreshBuffer is the amount of time before the certificate expires
+	// that a new refresh operation begins.
+	refreshBuffer time.Duration
+	// jitter is the fraction by which the time until the next refresh is
+	// randomly adjusted.
+	jitter float64
+	// randFloat returns a pseudo-random number in [0.0, 1.0).
+	randFloat func() float64
+	// backoffMin and backoffMax bound the delay before retrying after
+	// consecutive failed refreshes. If backoffMin is zero, a failed refresh
+	// is retried immediately.
+	backoffMin time.Duration
+	backoffMax time.Duration
+	// clock provides the current time and schedules refreshes.
+	clock clock
 
 	resultGuard sync.RWMutex
 	// cur represents the current refreshOperation that will be used to
@@ -147,28 +330,59 @@ type Instance struct {
 	// next represents a future or ongoing refreshOperation. Once complete,
 	// it will replace cur and schedule a replacement to occur.
 	next *refreshOperation
+	// lastRefresh is the time of the most recent successful refresh.
+	lastRefresh time.Time
+	// lastErr is the error of the most recent refresh, or nil if it
+	// succeeded.
+	lastErr error
+	// failures is the number of consecutive failed refreshes.
+	failures int
+
+	versionGuard sync.Mutex
+	// engineVersion is the cluster's database version. It is empty until
+	// the first successful call to EngineVersion.
+	engineVersion string
 
 	// ctx is the default ctx for refresh operations. Canceling it prevents
 	// new refresh operations from being triggered.
 	ctx    context.Context
 	cancel context.CancelFunc
+	// wg tracks scheduled and running refresh operations so that Close can
+	// wait for them to finish.
+	wg sync.WaitGroup
 }
 
 // NewInstance initializes a new Instance given an instance URI
 func NewInstance(
 	instance InstanceURI,
+	l debug.Logger,
 	client *alloydbadmin.AlloyDBAdminClient,
 	key *rsa.PrivateKey,
 	refreshTimeout time.Duration,
 	dialerID string,
+	opts ...Option,
 ) *Instance {
-	ctx, cancel := context.WithCancel(context.Background())
+	cfg := newRefreshConfig(opts...)
+	limiter := rate.NewLimiter(rate.Every(cfg.refreshInterval), cfg.refreshBurst)
+	if cfg.noRateLimit {
+		limiter = rate.NewLimiter(rate.Inf, 0)
+	}
+	ctx, cancel := context.WithCancel(cfg.ctx)
 	i := &Instance{
 		instanceURI:    instance,
+		logger:         l,
 		key:            key,
-		l:              rate.NewLimiter(rate.Every(refreshInterval), refreshBurst),
-		r:              newRefresher(client, dialerID),
+		l:              limiter,
+		r:              newRefresher(client, dialerID, cfg),
 		refreshTimeout: refreshTimeout,
+		errHandler:     cfg.errHandler,
+		onEvent:        cfg.onEvent,
+		refreshBuffer:  cfg.refreshBuffer,
+		jitter:         cfg.jitter,
+		randFloat:      cfg.randFloat,
+		backoffMin:     cfg.failureBackoffMin,
+		backoffMax:     cfg.failureBackoffMax,
+		clock:          cfg.clock,
 		ctx:            ctx,
 		cancel:         cancel,
 	}
@@ -186,20 +400,134 @@ func (i *Instance) OpenConns() *uint64 {
 	return &i.openConns
 }
 
+// MaxOpenConns reports the highest number of open connections. Callers that
+// increase the number of open connections are responsible for updating it.
+func (i *Instance) MaxOpenConns() *uint64 {
+	return &i.maxOpenConns
+}
+
 // Close closes the instance; it stops the refresh cycle and prevents it from
-// making additional calls to the AlloyDB Admin API.
+// making additional calls to the AlloyDB Admin API. Close blocks until any
+// in-flight refresh operation has returned.
 func (i *Instance) Close() error {
 	i.cancel()
+	i.resultGuard.Lock()
+	if i.cancelNext() {
+		// The refresh will never run, so unblock any callers waiting on
+		// its result.
+		close(i.next.ready)
+	}
+	i.resultGuard.Unlock()
+	i.wg.Wait()
 	return nil
 }
 
-// ConnectInfo returns an IP address of the AlloyDB instance.
-func (i *Instance) ConnectInfo(ctx context.Context) (string, *tls.Config, error) {
+// cancelNext stops the next refresh operation if it hasn't started yet and
+// reports whether it was stopped. A stopped operation fails and its applied
+// channel is closed, so WaitForRefresh doesn't wait on it. The caller must
+// hold resultGuard.
+func (i *Instance) cancelNext() bool {
+	if !i.next.cancel() {
+		return false
+	}
+	// The timer's func will never run, so release its slot.
+	i.wg.Done()
+	i.next.err = errtype.NewDialError(
+		"context was canceled or expired before refresh completed",
+		i.instanceURI.String(),
+		nil,
+	)
+	i.next.canceled = true
+	close(i.next.applied)
+	return true
+}
+
+// ConnectInfo returns an IP address of the requested type (e.g., PrivateIP)
+// of the AlloyDB instance. The returned TLS config is a copy, so callers may
+// modify it (e.g., set ServerName) without affecting the cached config.
+func (i *Instance) ConnectInfo(ctx context.Context, ipType string) (string, *tls.Config, error) {
+	addr, tlsCfg, _, err := i.ConnectInfoWithExpiry(ctx, ipType)
+	return addr, tlsCfg, err
+}
+
+// ConnectInfoWithExpiry is like ConnectInfo but also returns the expiration
+// time of the client certificate in the returned TLS config.
+func (i *Instance) ConnectInfoWithExpiry(ctx context.Context, ipType string) (string, *tls.Config, time.Time, error) {
+	res, err := i.result(ctx)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	return withExpiry(res.result, i.instanceURI, ipType)
+}
+
+// CertificateInfo returns the details of the client certificate used to
+// connect to the instance, waiting for an ongoing refresh if necessary.
+func (i *Instance) CertificateInfo(ctx context.Context) (CertificateInfo, error) {
 	res, err := i.result(ctx)
 	if err != nil {
-		return "", nil, err
+		return CertificateInfo{}, err
 	}
-	return res.result.instanceIPAddr, res.result.conf, nil
+	return res.result.certInfo(i.instanceURI)
+}
+
+// EngineVersion returns the database version of the instance's cluster as
+// reported by the AlloyDB Admin API (e.g., POSTGRES_15). The version is
+// retrieved once and cached for the lifetime of the Instance.
+func (i *Instance) EngineVersion(ctx context.Context) (string, error) {
+	i.versionGuard.Lock()
+	defer i.versionGuard.Unlock()
+	if i.engineVersion != "" {
+		return i.engineVersion, nil
+	}
+	v, err := fetchEngineVersion(ctx, i.r.client, i.instanceURI)
+	if err != nil {
+		return "", err
+	}
+	i.engineVersion = v
+	return v, nil
+}
+
+// IsValid reports whether the current connection info has been retrieved and
+// its certificate has not expired. It does not wait for an ongoing refresh.
+func (i *Instance) IsValid() bool {
+	i.resultGuard.RLock()
+	defer i.resultGuard.RUnlock()
+	return i.cur.isValid(i.clock.Now())
+}
+
+// CurrentRefreshAge reports how long ago the refresh operation providing the
+// current connection info completed, whether it succeeded or not. If that
+// refresh is still in progress, CurrentRefreshAge returns RefreshInProgress.
+// It does not wait for an ongoing refresh.
+func (i *Instance) CurrentRefreshAge() time.Duration {
+	i.resultGuard.RLock()
+	defer i.resultGuard.RUnlock()
+	select {
+	case <-i.cur.ready:
+		return i.clock.Now().Sub(i.cur.readyAt)
+	default:
+		return RefreshInProgress
+	}
+}
+
+// Health reports the state of the Instance's refresh cycle without blocking
+// on an ongoing refresh.
+func (i *Instance) Health() Health {
+	i.resultGuard.RLock()
+	defer i.resultGuard.RUnlock()
+	h := Health{
+		LastRefresh: i.lastRefresh,
+		LastErr:     i.lastErr,
+		OpenConns:   atomic.LoadUint64(&i.openConns),
+	}
+	select {
+	case <-i.cur.ready:
+		if i.cur.err == nil {
+			h.CertExpiry = i.cur.result.expiry
+		}
+	default:
+	}
+	return h
 }
 
 // ForceRefresh triggers an immediate refresh operation to be scheduled and
@@ -207,17 +535,89 @@ func (i *Instance) ConnectInfo(ctx context.Context) (string, *tls.Config, error)
 func (i *Instance) ForceRefresh() {
 	i.resultGuard.Lock()
 	defer i.resultGuard.Unlock()
+	// A forced refresh often follows a failed connection attempt, which may
+	// be caused by outdated metadata (e.g., a changed IP address).
+	i.r.md.invalidate()
+	select {
+	case <-i.ctx.Done():
+		// instance has been closed, don't schedule anything
+		return
+	default:
+	}
 	// If the next refresh hasn't started yet, we can cancel it and start an immediate one
-	if i.next.cancel() {
+	if i.cancelNext() {
 		i.next = i.scheduleRefresh(0)
 	}
 	// block all sequential connection attempts on the next refresh operation
 	// if current is invalid
-	if !i.cur.isValid() {
+	if !i.cur.isValid(i.clock.Now()) {
 		i.cur = i.next
 	}
 }
 
+// WaitForRefresh blocks until the next refresh operation (i.e., the one that
+// is in progress, or else the one scheduled next) completes and returns its
+// error. Following ForceRefresh, it waits on the forced refresh. Once
+// WaitForRefresh returns, the Instance uses the result of the refresh (if it
+// succeeded). If ctx is done first, WaitForRefresh returns the context's
+// error. If the Instance is closed first, it returns an error.
+func (i *Instance) WaitForRefresh(ctx context.Context) error {
+	for {
+		i.resultGuard.RLock()
+		next := i.next
+		i.resultGuard.RUnlock()
+		select {
+		case <-next.applied:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// A forced refresh replaces the operation it cancels, so wait on
+		// the replacement instead, unless the Instance was closed.
+		if next.canceled && i.ctx.Err() == nil {
+			continue
+		}
+		return next.err
+	}
+}
+
+// nextToken returns the time until the rate limiter allows the next refresh.
+func (i *Instance) nextToken() time.Duration {
+	r := i.l.Reserve()
+	defer r.Cancel()
+	return r.Delay()
+}
+
+// failureBackoff returns the delay before retrying after the current number of
+// consecutive failed refreshes. resultGuard must be held.
+func (i *Instance) failureBackoff() time.Duration {
+	if i.backoffMin <= 0 || i.failures == 0 {
+		return 0
+	}
+	b := i.backoffMin
+	for n := 1; n < i.failures && b < i.backoffMax; n++ {
+		b *= 2
+	}
+	if b > i.backoffMax {
+		b = i.backoffMax
+	}
+	return b
+}
+
+// limiterDelay returns how long a refresh that starts after d will wait on the
+// rate limiter, assuming no other refresh takes a token before then.
+func (i *Instance) limiterDelay(d time.Duration) time.Duration {
+	lim := i.l.Limit()
+	if lim == rate.Inf {
+		return 0
+	}
+	// The rate limiter uses the wall clock.
+	tokens := i.l.TokensAt(time.Now().Add(d))
+	if tokens >= 1 || lim <= 0 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / float64(lim) * float64(time.Second))
+}
+
 // result returns the most recent refresh result (waiting for it to complete if
 // necessary)
 func (i *Instance) result(ctx context.Context) (*refreshOperation, error) {
@@ -237,73 +637,190 @@ func (i *Instance) result(ctx context.Context) (*refreshOperation, error) {
 	return res, nil
 }
 
+// emit reports e, stamped with the instance and the current time, to the
+// event handler, if any.
+func (i *Instance) emit(e RefreshEvent) {
+	if i.onEvent == nil {
+		return
+	}
+	e.Instance = i.instanceURI.URI()
+	e.Time = i.clock.Now()
+	i.onEvent(e)
+}
+
 // refreshDuration returns the duration to wait before starting the next
 // refresh. Usually that duration will be half of the time until certificate
-// expiration.
-func refreshDuration(now, certExpiry time.Time) time.Duration {
+// expiration. If a freshly received certificate is already within the refresh
+// buffer, the local clock is likely skewed, so the duration is
+// clockSkewRefreshDelay rather than zero to avoid a tight refresh loop.
+func refreshDuration(now, certExpiry time.Time, buffer time.Duration) time.Duration {
 	d := certExpiry.Sub(now)
+	if d <= buffer {
+		return clockSkewRefreshDelay
+	}
 	if d < time.Hour {
-		// Something is wrong with the certification, refresh now.
-		if d < refreshBuffer {
-			return 0
-		}
-		// Otherwise wait until 4 minutes before expiration for next refresh cycle.
-		return d - refreshBuffer
+		// Wait until buffer before expiration for next refresh cycle.
+		return d - buffer
 	}
 	return d / 2
 }
 
+// jitterDuration randomly adjusts d by up to +/- fraction of d, where r is a
+// pseudo-random number in [0.0, 1.0). The result is never greater than
+// latest, nor less than zero.
+func jitterDuration(d time.Duration, fraction, r float64, latest time.Duration) time.Duration {
+	j := time.Duration(float64(d) * fraction * (2*r - 1))
+	d += j
+	if d > latest {
+		d = latest
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
 // scheduleRefresh schedules a refresh operation to be triggered after a given
 // duration. The returned refreshOperation can be used to either Cancel or Wait
 // for the operation's result.
 func (i *Instance) scheduleRefresh(d time.Duration) *refreshOperation {
+	nextRefresh := i.clock.Now().Add(d)
+	i.logger.Debugf("[%v] Refresh scheduled at %v (now + %v)",
+		i.instanceURI.String(), nextRefresh.Format(time.RFC3339), d.Round(time.Second))
+	i.emit(RefreshEvent{Type: RefreshScheduled, NextRefresh: nextRefresh})
 	r := &refreshOperation{}
 	r.ready = make(chan struct{})
-	r.timer = time.AfterFunc(d, func() {
+	r.applied = make(chan struct{})
+	i.wg.Add(1)
+	r.timer = i.clock.AfterFunc(d, func() {
+		defer i.wg.Done()
+		defer close(r.applied)
 		ctx, cancel := context.WithTimeout(i.ctx, i.refreshTimeout)
 		defer cancel()
 
+		i.logger.Debugf("[%v] Refresh started", i.instanceURI.String())
+		i.emit(RefreshEvent{Type: RefreshStarted})
+
+		// retryIn is the delay before the next refresh if this one fails.
+		var retryIn time.Duration
+		// The rate limiter uses the wall clock, so time the wait with it
+		// too.
+		waitStart := time.Now()
 		err := i.l.Wait(ctx)
-		if err != nil {
+		wait := time.Since(waitStart)
+		go trace.RecordRefreshWait(context.Background(), i.instanceURI.String(), i.r.dialerID, wait.Milliseconds())
+		if wait >= time.Millisecond {
+			i.logger.Debugf("[%v] Refresh waited %v on the rate limiter", i.instanceURI.String(), wait.Round(time.Millisecond))
+		}
+		if err != nil && ctx.Err() == nil {
+			// The limiter failed without the context being done, so
+			// waiting for the next token would exceed the refresh
+			// timeout. Retry once a token is available.
+			retryIn = i.nextToken()
+			r.err = errtype.NewDialError(
+				fmt.Sprintf("refresh was throttled by the rate limiter, next refresh allowed in %v",
+					retryIn.Round(time.Second)),
+				i.instanceURI.String(),
+				nil,
+			)
+			go trace.RecordRefreshResult(context.Background(), i.instanceURI.String(), i.r.dialerID, r.err)
+		} else if err != nil {
 			r.err = errtype.NewDialError(
 				"context was canceled or expired before refresh completed",
 				i.instanceURI.String(),
 				nil,
 			)
+			// performRefresh records its own result, so only record
+			// failures that occur before it runs.
+			go trace.RecordRefreshResult(context.Background(), i.instanceURI.String(), i.r.dialerID, r.err)
 		} else {
-			r.result, r.err = i.r.performRefresh(i.ctx, i.instanceURI, i.key)
+			r.result, r.err = i.r.performRefresh(ctx, i.instanceURI, i.key)
 		}
 
+		r.readyAt = i.clock.Now()
 		close(r.ready)
 
+		// Report the failure before acquiring the lock, so the handler
+		// can't deadlock the refresh cycle. Failures caused by closing
+		// the instance are expected and not reported.
+		if r.err != nil && i.errHandler != nil && i.ctx.Err() == nil {
+			i.errHandler(i.instanceURI.String(), r.err)
+		}
+		switch {
+		case r.err == nil:
+			i.emit(RefreshEvent{Type: RefreshSucceeded})
+		case i.ctx.Err() == nil:
+			i.emit(RefreshEvent{Type: RefreshFailed, Err: r.err})
+		}
+
 		// Once the refresh is complete, update "current" with working
 		// result and schedule a new refresh
 		i.resultGuard.Lock()
 		defer i.resultGuard.Unlock()
-		// if failed, scheduled the next refresh immediately
+		// if failed, schedule the next refresh immediately, or once the
+		// rate limiter allows it if the refresh was throttled
 		if r.err != nil {
-			i.next = i.scheduleRefresh(0)
+			i.logger.Debugf("[%v] Refresh failed, err = %v", i.instanceURI.String(), r.err)
+			i.lastErr = r.err
+			i.failures++
+			if b := i.failureBackoff(); b > retryIn {
+				retryIn = b
+			}
 			// If the latest result is bad, avoid replacing the
 			// used result while it's still valid and potentially
-			// able to provide successful connections. TODO: This
-			// means that errors while the current result is still
-			// valid are suppressed. We should try to surface
-			// errors in a more meaningful way.
-			if !i.cur.isValid() {
+			// able to provide successful connections. Errors
+			// while the current result is still valid are only
+			// surfaced through the errHandler.
+			if !i.cur.isValid(i.clock.Now()) {
 				i.cur = r
 			}
+			select {
+			case <-i.ctx.Done():
+				// instance has been closed, don't schedule anything
+				return
+			default:
+			}
+			i.next = i.scheduleRefresh(retryIn)
 			return
 		}
 		// Update the current results, and schedule the next refresh in
 		// the future
 		i.cur = r
+		i.lastRefresh = i.clock.Now()
+		i.lastErr = nil
+		i.failures = 0
 		select {
 		case <-i.ctx.Done():
 			// instance has been closed, don't schedule anything
 			return
 		default:
 		}
-		t := refreshDuration(time.Now(), i.cur.result.expiry)
+		now := i.clock.Now()
+		if skew := now.Sub(i.cur.result.expiry); skew > 0 {
+			i.logger.Debugf("[%v] Certificate expired %v before it was received (expiration = %v), "+
+				"the local clock may be ahead of the server's, next refresh in %v",
+				i.instanceURI.String(), skew.Round(time.Second),
+				i.cur.result.expiry.Format(time.RFC3339), clockSkewRefreshDelay)
+		}
+		t := refreshDuration(now, i.cur.result.expiry, i.refreshBuffer)
+		// Never jitter past the point the refresh buffer begins. Once that
+		// point has passed (e.g., with a skewed clock), t is kept.
+		latest := i.cur.result.expiry.Sub(now) - i.refreshBuffer
+		if i.jitter > 0 && latest > 0 {
+			t = jitterDuration(t, i.jitter, i.randFloat(), latest)
+		}
+		i.logger.Debugf("[%v] Refresh succeeded, certificate expiration = %v, next refresh = %v",
+			i.instanceURI.String(), i.cur.result.expiry.Format(time.RFC3339),
+			now.Add(t).Format(time.RFC3339))
+		// With short certificate lifetimes, waiting on the rate limiter
+		// may delay the next refresh until after the certificate expires,
+		// and connections fail in the meantime.
+		if wait := i.limiterDelay(t); !now.Add(t + wait).Before(i.cur.result.expiry) {
+			i.logger.Debugf("[%v] Certificate expires at %v, before the next refresh may start "+
+				"(now + %v, including %v waiting on the rate limiter), connections may fail until it completes",
+				i.instanceURI.String(), i.cur.result.expiry.Format(time.RFC3339),
+				(t + wait).Round(time.Second), wait.Round(time.Second))
+		}
 		i.next = i.scheduleRefresh(t)
 	})
 	return r
@@ -18,12 +18,18 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	alloydbadmin "cloud.google.com/go/alloydb/apiv1beta"
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
 	"cloud.google.com/go/alloydbconn/errtype"
 	"cloud.google.com/go/alloydbconn/internal/mock"
 	"golang.org/x/oauth2"
@@ -79,6 +85,95 @@ func TestParseInstURI(t *testing.T) {
 			if got != tc.want {
 				t.Fatalf("want = %v, got = %v", got, tc.want)
 			}
+			wantURI := strings.TrimPrefix(tc.in, "/")
+			if got := got.URI(); got != wantURI {
+				t.Fatalf("URI mismatch, want = %v, got = %v", wantURI, got)
+			}
+		})
+	}
+}
+
+func TestParseShortInstURI(t *testing.T) {
+	tcs := []struct {
+		desc string
+		in   string
+		want InstanceURI
+	}{
+		{
+			desc: "vanilla short form",
+			in:   "proj:reg:clust:name",
+			want: InstanceURI{
+				project: "proj",
+				region:  "reg",
+				cluster: "clust",
+				name:    "name",
+			},
+		},
+		{
+			desc: "with legacy domain-scoped project",
+			in:   "google.com:proj:reg:clust:name",
+			want: InstanceURI{
+				project: "google.com:proj",
+				region:  "reg",
+				cluster: "clust",
+				name:    "name",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := ParseInstURI(tc.in)
+			if err != nil {
+				t.Fatalf("want no error, got = %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("want = %v, got = %v", tc.want, got)
+			}
+			// Both forms parse to the same InstanceURI.
+			full, err := ParseInstURI(got.URI())
+			if err != nil {
+				t.Fatalf("want no error, got = %v", err)
+			}
+			if full != got {
+				t.Fatalf("want = %v, got = %v", got, full)
+			}
+		})
+	}
+}
+
+func TestInstanceURIAccessors(t *testing.T) {
+	u, err := ParseInstURI(
+		"projects/google.com:example/locations/us-central1/clusters/my-cluster/instances/my-instance",
+	)
+	if err != nil {
+		t.Fatalf("want no error, got = %v", err)
+	}
+	tcs := []struct {
+		desc string
+		got  string
+		want string
+	}{
+		{desc: "Project", got: u.Project(), want: "google.com:example"},
+		{desc: "Region", got: u.Region(), want: "us-central1"},
+		{desc: "Cluster", got: u.Cluster(), want: "my-cluster"},
+		{desc: "Name", got: u.Name(), want: "my-instance"},
+		{
+			desc: "URI",
+			got:  u.URI(),
+			want: "projects/google.com:example/locations/us-central1/clusters/my-cluster/instances/my-instance",
+		},
+		{
+			desc: "String",
+			got:  u.String(),
+			want: "projects/google.com:example/locations/us-central1/clusters/my-cluster/instances/my-instance",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Fatalf("want = %v, got = %v", tc.want, tc.got)
+			}
 		})
 	}
 }
@@ -104,18 +199,76 @@ func TestParseConnNameErrors(t *testing.T) {
 			desc: "empty",
 			in:   "::::",
 		},
+		{
+			desc: "trailing slash",
+			in:   "projects/proj/locations/reg/clusters/clust/instances/name/",
+		},
+		{
+			desc: "extra path segment",
+			in:   "projects/proj/locations/reg/clusters/clust/instances/name/extra",
+		},
+		{
+			desc: "extra path segment in cluster",
+			in:   "projects/proj/locations/reg/clusters/clust/extra/instances/name",
+		},
+		{
+			desc: "leading path segment",
+			in:   "v1/projects/proj/locations/reg/clusters/clust/instances/name",
+		},
+		{
+			desc: "missing instance",
+			in:   "projects/proj/locations/reg/clusters/clust/instances/",
+		},
+		{
+			desc: "short form with ambiguous colon",
+			in:   "proj:extra:reg:clust:name",
+		},
+		{
+			desc: "short form with too many components",
+			in:   "google.com:proj:reg:clust:name:extra",
+		},
+		{
+			desc: "short form with slash",
+			in:   "proj:reg:clust/name",
+		},
 	}
 
 	for _, tc := range tcs {
 		t.Run(tc.desc, func(t *testing.T) {
 			_, err := ParseInstURI(tc.in)
-			if err == nil {
-				t.Fatal("want error, got nil")
+			var wantErr *errtype.ConfigError
+			if !errors.As(err, &wantErr) {
+				t.Fatalf("want = %T, got = %v", wantErr, err)
+			}
+			if !strings.Contains(err.Error(), tc.in) {
+				t.Fatalf("want error to include input %q, got = %v", tc.in, err)
 			}
 		})
 	}
 }
 
+type nullLogger struct{}
+
+func (nullLogger) Debugf(string, ...interface{}) {}
+
+// spyLogger records all log lines.
+type spyLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *spyLogger) Debugf(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, fmt.Sprintf(format, args...))
+}
+
+func (s *spyLogger) Lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
 type stubTokenSource struct{}
 
 func (stubTokenSource) Token() (*oauth2.Token, error) {
@@ -150,14 +303,14 @@ func TestConnectInfo(t *testing.T) {
 	}
 
 	i := NewInstance(
-		testInstanceURI(),
+		testInstanceURI(), nullLogger{},
 		c, RSAKey, 30*time.Second, "dialer-id",
 	)
 	if err != nil {
 		t.Fatalf("failed to create mock instance: %v", err)
 	}
 
-	gotAddr, _, err := i.ConnectInfo(ctx)
+	gotAddr, _, err := i.ConnectInfo(ctx, PrivateIP)
 	if err != nil {
 		t.Fatalf("failed to retrieve connect info: %v", err)
 	}
@@ -170,6 +323,103 @@ func TestConnectInfo(t *testing.T) {
 	}
 }
 
+func TestConnectInfoWithEmptyIP(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr(""),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+	)
+	defer i.Close()
+
+	_, _, err = i.ConnectInfo(ctx, PrivateIP)
+	var wantErr *errtype.DialError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when IP address is empty, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestConnectInfoWithExpiry(t *testing.T) {
+	ctx := context.Background()
+	// Certificates encode their expiration with second precision.
+	wantExpiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(wantExpiry),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	tcs := []struct {
+		desc string
+		c    interface {
+			ConnectInfoWithExpiry(context.Context, string) (string, *tls.Config, time.Time, error)
+			Close() error
+		}
+	}{
+		{
+			desc: "refresh ahead cache",
+			c: NewInstance(
+				testInstanceURI(), nullLogger{},
+				c, RSAKey, 30*time.Second, "dialer-id",
+			),
+		},
+		{
+			desc: "lazy refresh cache",
+			c: NewLazyRefreshCache(
+				testInstanceURI(), nullLogger{},
+				c, RSAKey, 30*time.Second, "dialer-id",
+			),
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer tc.c.Close()
+			_, _, gotExpiry, err := tc.c.ConnectInfoWithExpiry(ctx, PrivateIP)
+			if err != nil {
+				t.Fatalf("failed to retrieve connect info: %v", err)
+			}
+			if !gotExpiry.Equal(wantExpiry) {
+				t.Fatalf("want expiry = %v, got = %v", wantExpiry, gotExpiry)
+			}
+		})
+	}
+}
+
 func testInstanceURI() InstanceURI {
 	i, _ := ParseInstURI("/projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance")
 	return i
@@ -184,14 +434,15 @@ func TestConnectInfoErrors(t *testing.T) {
 
 	// Use a timeout that should fail instantly
 	i := NewInstance(
-		testInstanceURI(),
+		testInstanceURI(), nullLogger{},
 		c, RSAKey, 0, "dialer-id",
 	)
 	if err != nil {
 		t.Fatalf("failed to initialize Instance: %v", err)
 	}
+	defer i.Close()
 
-	_, _, err = i.ConnectInfo(ctx)
+	_, _, err = i.ConnectInfo(ctx, PrivateIP)
 	var wantErr *errtype.DialError
 	if !errors.As(err, &wantErr) {
 		t.Fatalf("when connect info fails, want = %T, got = %v", wantErr, err)
@@ -207,7 +458,7 @@ func TestClose(t *testing.T) {
 
 	// Set up an instance and then close it immediately
 	i := NewInstance(
-		testInstanceURI(),
+		testInstanceURI(), nullLogger{},
 		c, RSAKey, 30, "dialer-ider",
 	)
 	if err != nil {
@@ -215,7 +466,7 @@ func TestClose(t *testing.T) {
 	}
 	i.Close()
 
-	_, _, err = i.ConnectInfo(ctx)
+	_, _, err = i.ConnectInfo(ctx, PrivateIP)
 	if !strings.Contains(err.Error(), "context was canceled or expired") {
 		t.Fatalf("failed to retrieve connect info: %v", err)
 	}
@@ -246,17 +497,62 @@ func TestRefreshDuration(t *testing.T) {
 		{
 			desc:   "when expiration is less than 4 minutes",
 			expiry: now.Add(3 * time.Minute),
-			want:   0,
+			want:   clockSkewRefreshDelay,
 		},
 		{
 			desc:   "when expiration is now",
 			expiry: now,
-			want:   0,
+			want:   clockSkewRefreshDelay,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := refreshDuration(now, tc.expiry, refreshBuffer)
+			// round to the second to remove millisecond differences
+			if got.Round(time.Second) != tc.want {
+				t.Fatalf("time until refresh: want = %v, got = %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRefreshDurationWithCustomBuffer(t *testing.T) {
+	now := time.Now()
+	buffer := 10 * time.Minute
+	tcs := []struct {
+		desc   string
+		expiry time.Time
+		want   time.Duration
+	}{
+		{
+			desc:   "when expiration is greater than 1 hour",
+			expiry: now.Add(4 * time.Hour),
+			want:   2 * time.Hour,
+		},
+		{
+			desc:   "when expiration is less than 1 hour, but greater than the buffer",
+			expiry: now.Add(30 * time.Minute),
+			want:   20 * time.Minute,
+		},
+		{
+			desc:   "when expiration is equal to the buffer",
+			expiry: now.Add(buffer),
+			want:   clockSkewRefreshDelay,
+		},
+		{
+			desc:   "when expiration is less than the buffer",
+			expiry: now.Add(buffer - time.Second),
+			want:   clockSkewRefreshDelay,
+		},
+		{
+			desc:   "when expiration is just greater than the buffer",
+			expiry: now.Add(buffer + time.Second),
+			want:   time.Second,
 		},
 	}
 	for _, tc := range tcs {
 		t.Run(tc.desc, func(t *testing.T) {
-			got := refreshDuration(now, tc.expiry)
+			got := refreshDuration(now, tc.expiry, buffer)
 			// round to the second to remove millisecond differences
 			if got.Round(time.Second) != tc.want {
 				t.Fatalf("time until refresh: want = %v, got = %v", tc.want, got)
@@ -264,3 +560,1160 @@ func TestRefreshDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestRefreshDurationWithClockSkew(t *testing.T) {
+	now := time.Now()
+	tcs := []struct {
+		desc   string
+		expiry time.Time
+		want   time.Duration
+	}{
+		{
+			desc:   "when the clock is 58 minutes ahead of a 1 hour certificate",
+			expiry: now.Add(2 * time.Minute),
+			want:   clockSkewRefreshDelay,
+		},
+		{
+			desc:   "when expiration has just passed",
+			expiry: now.Add(-time.Second),
+			want:   clockSkewRefreshDelay,
+		},
+		{
+			desc:   "when expiration is an hour in the past",
+			expiry: now.Add(-time.Hour),
+			want:   clockSkewRefreshDelay,
+		},
+		{
+			desc:   "when expiration is days in the past",
+			expiry: now.Add(-72 * time.Hour),
+			want:   clockSkewRefreshDelay,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := refreshDuration(now, tc.expiry, refreshBuffer)
+			if got != tc.want {
+				t.Fatalf("time until refresh: want = %v, got = %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestConnectInfoErrorsWhenIPTypeUnavailable(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+	)
+	defer i.Close()
+
+	// The instance only has a private IP.
+	_, _, err = i.ConnectInfo(ctx, PublicIP)
+	var wantErr *errtype.ConfigError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when IP type is unavailable, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestInstanceLogsRefresh(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	spy := &spyLogger{}
+	i := NewInstance(
+		testInstanceURI(), spy,
+		c, RSAKey, 30*time.Second, "dialer-id",
+	)
+	defer i.Close()
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+
+	want := []string{
+		"Refresh scheduled",
+		"Refresh started",
+		"Refresh succeeded",
+		// the next refresh is scheduled after a successful refresh
+		"Refresh scheduled",
+	}
+	// The refresh completes before the result is logged, so allow the
+	// refresh goroutine a chance to finish.
+	var got []string
+	for n := 0; n < 10; n++ {
+		got = spy.Lines()
+		if len(got) >= len(want) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("log lines mismatch, want = %v, got = %v", want, got)
+	}
+	for n, w := range want {
+		if !strings.Contains(got[n], w) {
+			t.Fatalf("log line %d mismatch, want to contain = %q, got = %q", n, w, got[n])
+		}
+	}
+}
+
+func TestRefreshErrorHandlerCalledWhileCurrentIsValid(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// Only the first refresh succeeds. All subsequent API calls fail.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	type handlerCall struct {
+		inst string
+		err  error
+	}
+	calls := make(chan handlerCall, 10)
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithRefreshErrorHandler(func(inst string, err error) {
+			calls <- handlerCall{inst: inst, err: err}
+		}),
+	)
+	defer i.Close()
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+
+	i.ForceRefresh()
+
+	select {
+	case got := <-calls:
+		if want := testInstanceURI(); got.inst != want.String() {
+			t.Fatalf("instance mismatch, want = %v, got = %v", want.String(), got.inst)
+		}
+		if got.err == nil {
+			t.Fatal("want error, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh error handler was not called")
+	}
+
+	// The current result is still valid and continues to be used.
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+}
+
+func TestCloseWaitsForInFlightRefresh(t *testing.T) {
+	ctx := context.Background()
+	// No API calls succeed, so every refresh fails and calls the handler.
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	started := make(chan struct{})
+	var once sync.Once
+	var finished int32
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithRefreshErrorHandler(func(string, error) {
+			once.Do(func() { close(started) })
+			// Simulate a slow refresh that is still running when Close
+			// is called.
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&finished, 1)
+		}),
+	)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh did not start")
+	}
+	if err := i.Close(); err != nil {
+		t.Fatalf("want no error on Close, got = %v", err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("want Close to wait for the in-flight refresh to return")
+	}
+
+	// Calling ForceRefresh after Close does not schedule a new refresh.
+	i.ForceRefresh()
+	i.wg.Wait()
+}
+
+func TestRefreshThrottledByRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	errs := make(chan error, 10)
+	// Allow a single refresh per hour, so the forced refresh is throttled
+	// for much longer than the refresh timeout.
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithRefreshRateLimit(time.Hour, 1),
+		WithRefreshErrorHandler(func(_ string, err error) {
+			errs <- err
+		}),
+	)
+	defer i.Close()
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	i.ForceRefresh()
+
+	select {
+	case err := <-errs:
+		var wantErr *errtype.DialError
+		if !errors.As(err, &wantErr) {
+			t.Fatalf("want = %T, got = %v", wantErr, err)
+		}
+		if !strings.Contains(err.Error(), "throttled") {
+			t.Fatalf("want throttled error, got = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh error handler was not called")
+	}
+
+	// The throttled refresh is retried once the limiter allows it, not
+	// immediately.
+	select {
+	case err := <-errs:
+		t.Fatalf("want no immediate retry, got error = %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestForceRefreshNotThrottledWithoutRateLimit(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// The initial refresh followed by three forced refreshes exceeds the
+	// default burst of 2.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 4),
+		mock.CreateEphemeralSuccess(inst, 4),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithNoRefreshRateLimit(),
+		WithRefreshErrorHandler(func(_ string, err error) {
+			t.Errorf("want no refresh errors, got = %v", err)
+		}),
+	)
+	defer i.Close()
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	for n := 0; n < 3; n++ {
+		last := i.Health().LastRefresh
+		i.ForceRefresh()
+		deadline := time.Now().Add(5 * time.Second)
+		for !i.Health().LastRefresh.After(last) {
+			if time.Now().After(deadline) {
+				t.Fatalf("forced refresh %d did not complete", n+1)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestJitterDuration(t *testing.T) {
+	d := 30 * time.Minute
+	tcs := []struct {
+		desc   string
+		r      float64
+		latest time.Duration
+		want   time.Duration
+	}{
+		{
+			desc:   "with the smallest random number",
+			r:      0,
+			latest: time.Hour,
+			want:   27 * time.Minute,
+		},
+		{
+			desc:   "with the middle random number",
+			r:      0.5,
+			latest: time.Hour,
+			want:   30 * time.Minute,
+		},
+		{
+			desc:   "with a large random number",
+			r:      0.75,
+			latest: time.Hour,
+			want:   31*time.Minute + 30*time.Second,
+		},
+		{
+			desc:   "when jitter would exceed the latest refresh",
+			r:      0.75,
+			latest: 31 * time.Minute,
+			want:   31 * time.Minute,
+		},
+		{
+			desc:   "when the latest refresh has passed",
+			r:      0.5,
+			latest: -time.Minute,
+			want:   0,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := jitterDuration(d, 0.1, tc.r, tc.latest)
+			if got != tc.want {
+				t.Fatalf("want = %v, got = %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestJitterDurationSpreadsRefreshes(t *testing.T) {
+	d := 30 * time.Minute
+	r := mathrand.New(mathrand.NewSource(1))
+	lo, hi := d, d
+	for n := 0; n < 1000; n++ {
+		got := jitterDuration(d, 0.1, r.Float64(), time.Hour)
+		if got < 27*time.Minute || got > 33*time.Minute {
+			t.Fatalf("want jittered duration within +/- 10%%, got = %v", got)
+		}
+		if got < lo {
+			lo = got
+		}
+		if got > hi {
+			hi = got
+		}
+	}
+	// With 1000 samples, the durations should cover most of the range.
+	if lo > 27*time.Minute+30*time.Second || hi < 32*time.Minute+30*time.Second {
+		t.Fatalf("want durations spread across +/- 10%%, got range = [%v, %v]", lo, hi)
+	}
+}
+
+func TestInstanceJittersRefresh(t *testing.T) {
+	ctx := context.Background()
+	// The mock certificate expires in 24 hours, so the next refresh would
+	// be in 12 hours without jitter.
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	spy := &spyLogger{}
+	i := NewInstance(
+		testInstanceURI(), spy,
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithRefreshJitter(0.1),
+		// Always use the largest negative jitter.
+		withRandSource(func() float64 { return 0 }),
+	)
+	defer i.Close()
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+
+	want := "now + 10h48m0s"
+	for n := 0; n < 100; n++ {
+		for _, l := range spy.Lines() {
+			if strings.Contains(l, want) {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("want refresh scheduled at %q, got = %v", want, spy.Lines())
+}
+
+// fakeClock is a clock whose time only moves when advanced. Scheduled funcs
+// run synchronously when the clock is advanced to their scheduled time.
+type fakeClock struct {
+	mu        sync.Mutex
+	now       time.Time
+	timers    []*fakeTimer
+	scheduled []time.Duration
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	c.scheduled = append(c.scheduled, d)
+	return t
+}
+
+// Advance moves the clock forward by d and runs all funcs that are due, in
+// the order they were scheduled, including any they schedule in turn.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		t := c.nextDue()
+		if t == nil {
+			return
+		}
+		t.f()
+	}
+}
+
+// nextDue returns the first timer that is due and marks it as fired, or nil
+// if no timer is due.
+func (c *fakeClock) nextDue() *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.timers {
+		if !t.done && !t.at.After(c.now) {
+			t.done = true
+			return t
+		}
+	}
+	return nil
+}
+
+// Scheduled returns the durations of all scheduled funcs.
+func (c *fakeClock) Scheduled() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration{}, c.scheduled...)
+}
+
+type fakeTimer struct {
+	c    *fakeClock
+	at   time.Time
+	f    func()
+	done bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	return true
+}
+
+func TestRefreshCycleWithFakeClock(t *testing.T) {
+	ctx := context.Background()
+	// Certificates carry expiry times with second precision.
+	start := time.Now().Truncate(time.Second)
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(start.Add(2*time.Hour)),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	clk := newFakeClock(start)
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+	defer i.Close()
+
+	// The initial refresh is scheduled immediately and runs once the clock
+	// is advanced.
+	clk.Advance(0)
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	// The next refresh is scheduled halfway to the certificate's expiry.
+	got := clk.Scheduled()
+	if len(got) != 2 || got[0] != 0 || got[1] != time.Hour {
+		t.Fatalf("want refreshes scheduled at [0s 1h0m0s], got = %v", got)
+	}
+
+	// Nothing fires before the next refresh is due.
+	clk.Advance(time.Hour - time.Second)
+	if got := len(clk.Scheduled()); got != 2 {
+		t.Fatalf("want no refresh before it is due, got %v scheduled", got)
+	}
+	// Once due, the refresh runs and schedules another halfway to the
+	// certificate's expiry.
+	clk.Advance(time.Second)
+	got = clk.Scheduled()
+	if len(got) != 3 {
+		t.Fatalf("want the second refresh to run, got scheduled = %v", got)
+	}
+	if want := 30 * time.Minute; got[2] != want {
+		t.Fatalf("want next refresh in %v, got = %v", want, got[2])
+	}
+}
+
+func TestRefreshDelayedWhenCertExpiresWithinBuffer(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	// The certificate expires within the refresh buffer, so each refresh
+	// is followed by a refresh after clockSkewRefreshDelay rather than an
+	// immediate one.
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(start.Add(time.Minute)),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	clk := newFakeClock(start)
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+	// Run only the initial refresh: the follow-up refresh is scheduled, but
+	// the fake clock is not advanced again, so it never runs.
+	t0 := clk.nextDue()
+	t0.f()
+	defer i.Close()
+
+	got := clk.Scheduled()
+	if len(got) != 2 || got[1] != clockSkewRefreshDelay {
+		t.Fatalf("want the next refresh scheduled in %v, got = %v", clockSkewRefreshDelay, got)
+	}
+}
+
+func TestWarnWhenCertExpiresBeforeRateLimitedRefresh(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	// The certificate expires sooner than the rate limiter allows the next
+	// refresh.
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(start.Add(10*time.Second)),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	spy := &spyLogger{}
+	clk := newFakeClock(start)
+	i := NewInstance(
+		testInstanceURI(), spy,
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithRefreshRateLimit(time.Minute, 1),
+		withClock(clk),
+	)
+	// Run only the initial refresh, which takes the only token.
+	t0 := clk.nextDue()
+	t0.f()
+	defer i.Close()
+
+	want := "before the next refresh may start"
+	for _, l := range spy.Lines() {
+		if strings.Contains(l, want) {
+			return
+		}
+	}
+	t.Fatalf("want log containing %q, got = %v", want, spy.Lines())
+}
+
+func TestInstanceHealthReportsRefreshError(t *testing.T) {
+	ctx := context.Background()
+	// With no expected requests, every API call fails.
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	clk := newFakeClock(time.Now())
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+	defer i.Close()
+
+	if h := i.Health(); h.LastErr != nil || !h.CertExpiry.IsZero() {
+		t.Fatalf("want empty health before the first refresh, got = %+v", h)
+	}
+	// Run only the initial refresh.
+	clk.nextDue().f()
+
+	h := i.Health()
+	if h.LastErr == nil {
+		t.Error("want refresh error, got nil")
+	}
+	if !h.LastRefresh.IsZero() || !h.CertExpiry.IsZero() {
+		t.Errorf("want no successful refresh, got = %+v", h)
+	}
+}
+
+func TestRefreshFailureBackoff(t *testing.T) {
+	ctx := context.Background()
+	// With no expected requests, every API call fails.
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	clk := newFakeClock(time.Now())
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithNoRefreshRateLimit(),
+		WithRefreshFailureBackoff(time.Second, 4*time.Second),
+		withClock(clk),
+	)
+	defer i.Close()
+
+	// Run the initial refresh, then each retry once it's due.
+	clk.Advance(0)
+	clk.Advance(time.Second)
+	clk.Advance(2 * time.Second)
+	clk.Advance(4 * time.Second)
+
+	want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	if got := clk.Scheduled(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want refreshes scheduled in %v, got = %v", want, got)
+	}
+}
+
+func TestInstanceWaitForRefresh(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		// The forced refresh has made the second calls.
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+	)
+	defer i.Close()
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	first := i.Health().LastRefresh
+
+	i.ForceRefresh()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := i.WaitForRefresh(ctx); err != nil {
+		t.Fatalf("expected WaitForRefresh to succeed, but got error: %v", err)
+	}
+	if got := i.Health().LastRefresh; !got.After(first) {
+		t.Fatalf("want a refresh after %v, got last refresh = %v", first, got)
+	}
+}
+
+func TestInstanceWaitForRefreshHonorsContext(t *testing.T) {
+	ctx := context.Background()
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	// The initial refresh doesn't run until the clock is advanced.
+	clk := newFakeClock(time.Now())
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+	defer i.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := i.WaitForRefresh(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want = %v, got = %v", context.Canceled, err)
+	}
+}
+
+func TestInstanceWaitForRefreshFollowsForcedRefresh(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	// The initial refresh doesn't run until the clock is advanced.
+	clk := newFakeClock(time.Now())
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+	defer i.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- i.WaitForRefresh(ctx) }()
+	// Give WaitForRefresh time to start waiting on the initial refresh.
+	time.Sleep(100 * time.Millisecond)
+
+	// The forced refresh cancels the initial one and replaces it.
+	i.ForceRefresh()
+	clk.nextDue().f()
+	if err := <-errc; err != nil {
+		t.Fatalf("expected WaitForRefresh to succeed, but got error: %v", err)
+	}
+}
+
+func TestInstanceWaitForRefreshReturnsOnClose(t *testing.T) {
+	ctx := context.Background()
+	mc, url, cleanup := mock.HTTPClient()
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	// The initial refresh doesn't run until the clock is advanced.
+	clk := newFakeClock(time.Now())
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- i.WaitForRefresh(ctx) }()
+	// Give WaitForRefresh time to start waiting on the initial refresh.
+	time.Sleep(100 * time.Millisecond)
+
+	i.Close()
+	err = <-errc
+	var wantErr *errtype.DialError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when the instance is closed, want = %T, got = %v", wantErr, err)
+	}
+}
+
+func TestInstanceIsValid(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	clk := newFakeClock(time.Now())
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+	defer i.Close()
+
+	// The initial refresh doesn't run until the clock is advanced.
+	if i.IsValid() {
+		t.Fatal("want IsValid = false before the first refresh, got true")
+	}
+	clk.Advance(0)
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	if !i.IsValid() {
+		t.Fatal("want IsValid = true after the first refresh, got false")
+	}
+}
+
+func TestInstanceCurrentRefreshAge(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	clk := newFakeClock(time.Now())
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+		withClock(clk),
+	)
+	defer i.Close()
+
+	// The initial refresh doesn't run until the clock is advanced.
+	if got := i.CurrentRefreshAge(); got != RefreshInProgress {
+		t.Fatalf("want age = RefreshInProgress before the first refresh, got = %v", got)
+	}
+	clk.Advance(0)
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	if got := i.CurrentRefreshAge(); got != 0 {
+		t.Fatalf("want age = 0 right after the refresh, got = %v", got)
+	}
+	// Advancing by less than the time until the next refresh only ages the
+	// current result.
+	clk.Advance(10 * time.Minute)
+	if got := i.CurrentRefreshAge(); got != 10*time.Minute {
+		t.Fatalf("want age = %v, got = %v", 10*time.Minute, got)
+	}
+}
+
+func TestConnectInfoReturnsCopyOfTLSConfig(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr("10.0.0.1"),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewInstance(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+	)
+	defer i.Close()
+
+	// Callers mutate the returned config concurrently, e.g., to override
+	// the server name. Run with -race to detect shared state.
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			_, cfg, err := i.ConnectInfo(ctx, PrivateIP)
+			if err != nil {
+				t.Errorf("failed to retrieve connect info: %v", err)
+				return
+			}
+			cfg.ServerName = fmt.Sprintf("caller-%d", n)
+			cfg.NextProtos = []string{"h2"}
+		}(n)
+	}
+	wg.Wait()
+
+	_, cfg, err := i.ConnectInfo(ctx, PrivateIP)
+	if err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	if cfg.ServerName != "10.0.0.1" {
+		t.Fatalf("want cached server name = %v, got = %v", "10.0.0.1", cfg.ServerName)
+	}
+	if cfg.NextProtos != nil {
+		t.Fatalf("want cached NextProtos = nil, got = %v", cfg.NextProtos)
+	}
+}
+
+func TestRefreshWithClockSkew(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	// The local clock is ahead of the server's, so the certificate appears
+	// to have expired when it's received.
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithCertExpiry(start.Add(-time.Hour)),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	spy := &spyLogger{}
+	clk := newFakeClock(start)
+	i := NewInstance(
+		testInstanceURI(), spy,
+		c, RSAKey, 30*time.Second, "dialer-id",
+		WithNoRefreshRateLimit(),
+		WithRefreshJitter(0.5),
+		withClock(clk),
+	)
+	// Run only the initial refresh.
+	clk.nextDue().f()
+	defer i.Close()
+
+	got := clk.Scheduled()
+	if want := []time.Duration{0, clockSkewRefreshDelay}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("scheduled refreshes: want = %v, got = %v", want, got)
+	}
+	want := "the local clock may be ahead of the server's"
+	for _, l := range spy.Lines() {
+		if strings.Contains(l, want) {
+			return
+		}
+	}
+	t.Fatalf("want log containing %q, got = %v", want, spy.Lines())
+}
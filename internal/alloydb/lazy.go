@@ -0,0 +1,285 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alloydb
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
+	"cloud.google.com/go/alloydbconn/debug"
+)
+
+// LazyRefreshCache caches connection info and refreshes the cache only when
+// a caller requests connection info and the current certificate is expired or
+// close to expiring. Unlike Instance, LazyRefreshCache never starts a
+// background refresh cycle, which makes it a good fit for environments where
+// the CPU may be throttled outside of a request context (e.g., Cloud Run).
+type LazyRefreshCache struct {
+	// openConns is the number of open connections to the instance.
+	openConns uint64
+	// maxOpenConns is the highest number of open connections to the
+	// instance.
+	maxOpenConns uint64
+	// validUntil is the expiry of the cached certificate in Unix
+	// nanoseconds, or zero if there is none. It is read without holding mu,
+	// which is held during refreshes.
+	validUntil int64
+	// refreshedAt is the time of the most recent successful refresh in Unix
+	// nanoseconds, or zero if there is none. Like validUntil, it is read
+	// without holding mu.
+	refreshedAt int64
+
+	instanceURI InstanceURI
+	logger      debug.Logger
+	key         *rsa.PrivateKey
+	// refreshTimeout sets the maximum duration a refresh can run for.
+	refreshTimeout time.Duration
+	// refreshBuffer is the amount of time before the certificate expires
+	// that the cached connection info is considered stale.
+	refreshBuffer time.Duration
+	r             refresher
+	// errHandler, if set, is called whenever a refresh fails.
+	errHandler func(instance string, err error)
+	// onEvent, if set, is called at each stage of a refresh.
+	onEvent func(RefreshEvent)
+
+	mu sync.Mutex
+	// needsRefresh is set by ForceRefresh and causes the next call to
+	// ConnectInfo to refresh regardless of the cached certificate's expiry.
+	needsRefresh bool
+	cached       refreshResult
+	// lastRefresh is the time of the most recent successful refresh.
+	lastRefresh time.Time
+	// lastErr is the error of the most recent refresh, or nil if it
+	// succeeded.
+	lastErr error
+	// engineVersion is the cluster's database version. It is empty until
+	// the first successful call to EngineVersion.
+	engineVersion string
+}
+
+// NewLazyRefreshCache initializes a new LazyRefreshCache. No calls are made
+// to the AlloyDB Admin API until the first call to ConnectInfo.
+func NewLazyRefreshCache(
+	instance InstanceURI,
+	l debug.Logger,
+	client *alloydbadmin.AlloyDBAdminClient,
+	key *rsa.PrivateKey,
+	refreshTimeout time.Duration,
+	dialerID string,
+	opts ...Option,
+) *LazyRefreshCache {
+	cfg := newRefreshConfig(opts...)
+	return &LazyRefreshCache{
+		instanceURI:    instance,
+		logger:         l,
+		key:            key,
+		refreshTimeout: refreshTimeout,
+		refreshBuffer:  cfg.refreshBuffer,
+		r:              newRefresher(client, dialerID, cfg),
+		errHandler:     cfg.errHandler,
+		onEvent:        cfg.onEvent,
+	}
+}
+
+// OpenConns reports the number of open connections.
+func (c *LazyRefreshCache) OpenConns() *uint64 {
+	return &c.openConns
+}
+
+// MaxOpenConns reports the highest number of open connections. Callers that
+// increase the number of open connections are responsible for updating it.
+func (c *LazyRefreshCache) MaxOpenConns() *uint64 {
+	return &c.maxOpenConns
+}
+
+// ConnectInfo returns an IP address of the requested type (e.g., PrivateIP)
+// of the AlloyDB instance, refreshing the cached connection info first if the
+// certificate has expired or will expire soon. As with Instance, the returned
+// TLS config is a copy that callers may modify.
+func (c *LazyRefreshCache) ConnectInfo(ctx context.Context, ipType string) (string, *tls.Config, error) {
+	addr, tlsCfg, _, err := c.ConnectInfoWithExpiry(ctx, ipType)
+	return addr, tlsCfg, err
+}
+
+// ConnectInfoWithExpiry is like ConnectInfo but also returns the expiration
+// time of the client certificate in the returned TLS config.
+func (c *LazyRefreshCache) ConnectInfoWithExpiry(ctx context.Context, ipType string) (string, *tls.Config, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.needsRefreshLocked() {
+		return withExpiry(c.cached, c.instanceURI, ipType)
+	}
+	if err := c.refresh(ctx); err != nil {
+		return "", nil, time.Time{}, err
+	}
+	return withExpiry(c.cached, c.instanceURI, ipType)
+}
+
+// needsRefreshLocked reports whether the cached connection info must be
+// refreshed before it's used. c.mu must be held.
+func (c *LazyRefreshCache) needsRefreshLocked() bool {
+	// Use the cached result until the refresh buffer before the certificate
+	// expires begins.
+	return c.needsRefresh || c.cached.conf == nil ||
+		!time.Now().Before(c.cached.expiry.Add(-c.refreshBuffer))
+}
+
+// refresh retrieves new connection info and caches it. c.mu must be held.
+func (c *LazyRefreshCache) refresh(ctx context.Context) error {
+	c.logger.Debugf("[%v] Refresh started", c.instanceURI.String())
+	c.emit(RefreshEvent{Type: RefreshStarted})
+	ctx, cancel := context.WithTimeout(ctx, c.refreshTimeout)
+	defer cancel()
+	res, err := c.r.performRefresh(ctx, c.instanceURI, c.key)
+	if err != nil {
+		c.logger.Debugf("[%v] Refresh failed, err = %v", c.instanceURI.String(), err)
+		c.emit(RefreshEvent{Type: RefreshFailed, Err: err})
+		c.lastErr = err
+		if c.errHandler != nil {
+			c.errHandler(c.instanceURI.String(), err)
+		}
+		return err
+	}
+	c.logger.Debugf("[%v] Refresh succeeded, certificate expiration = %v",
+		c.instanceURI.String(), res.expiry.Format(time.RFC3339))
+	c.cached = res
+	atomic.StoreInt64(&c.validUntil, res.expiry.UnixNano())
+	c.lastRefresh = time.Now()
+	atomic.StoreInt64(&c.refreshedAt, c.lastRefresh.UnixNano())
+	c.lastErr = nil
+	c.needsRefresh = false
+	c.emit(RefreshEvent{Type: RefreshSucceeded})
+	return nil
+}
+
+// emit reports e, stamped with the instance and the current time, to the
+// event handler, if any.
+func (c *LazyRefreshCache) emit(e RefreshEvent) {
+	if c.onEvent == nil {
+		return
+	}
+	e.Instance = c.instanceURI.URI()
+	e.Time = time.Now()
+	c.onEvent(e)
+}
+
+// WaitForRefresh performs the refresh that the next request for connection
+// info would perform (e.g., after ForceRefresh) and returns its error. If the
+// cached connection info doesn't need to be refreshed, WaitForRefresh returns
+// nil immediately.
+func (c *LazyRefreshCache) WaitForRefresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.needsRefreshLocked() {
+		return nil
+	}
+	return c.refresh(ctx)
+}
+
+// withExpiry returns the address of the requested type along with the TLS
+// config and certificate expiration of the refresh result.
+func withExpiry(r refreshResult, inst InstanceURI, ipType string) (string, *tls.Config, time.Time, error) {
+	addr, tlsCfg, err := r.addr(inst, ipType)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	return addr, tlsCfg, r.expiry, nil
+}
+
+// CertificateInfo returns the details of the client certificate used to
+// connect to the instance, refreshing the cached connection info first as
+// ConnectInfo does.
+func (c *LazyRefreshCache) CertificateInfo(ctx context.Context) (CertificateInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.needsRefreshLocked() {
+		if err := c.refresh(ctx); err != nil {
+			return CertificateInfo{}, err
+		}
+	}
+	return c.cached.certInfo(c.instanceURI)
+}
+
+// EngineVersion returns the database version of the instance's cluster as
+// reported by the AlloyDB Admin API (e.g., POSTGRES_15). The version is
+// retrieved once and cached for the lifetime of the LazyRefreshCache.
+func (c *LazyRefreshCache) EngineVersion(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.engineVersion != "" {
+		return c.engineVersion, nil
+	}
+	v, err := fetchEngineVersion(ctx, c.r.client, c.instanceURI)
+	if err != nil {
+		return "", err
+	}
+	c.engineVersion = v
+	return v, nil
+}
+
+// IsValid reports whether connection info has been cached and its
+// certificate has not expired. It does not wait for a refresh in progress.
+func (c *LazyRefreshCache) IsValid() bool {
+	v := atomic.LoadInt64(&c.validUntil)
+	return v != 0 && time.Now().Before(time.Unix(0, v))
+}
+
+// CurrentRefreshAge reports how long ago the cached connection info was
+// retrieved. If no connection info has been cached yet, CurrentRefreshAge
+// returns RefreshInProgress. It does not wait for a refresh in progress.
+func (c *LazyRefreshCache) CurrentRefreshAge() time.Duration {
+	v := atomic.LoadInt64(&c.refreshedAt)
+	if v == 0 {
+		return RefreshInProgress
+	}
+	return time.Since(time.Unix(0, v))
+}
+
+// Health reports the state of the cached connection info. It waits for a
+// refresh in progress to complete.
+func (c *LazyRefreshCache) Health() Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := Health{
+		LastRefresh: c.lastRefresh,
+		LastErr:     c.lastErr,
+		OpenConns:   atomic.LoadUint64(&c.openConns),
+	}
+	if c.cached.conf != nil {
+		h.CertExpiry = c.cached.expiry
+	}
+	return h
+}
+
+// ForceRefresh invalidates the cached connection info so that the next call
+// to ConnectInfo fetches fresh connection info.
+func (c *LazyRefreshCache) ForceRefresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.needsRefresh = true
+	c.r.md.invalidate()
+}
+
+// Close is a no-op and provided purely for a consistent interface with
+// Instance.
+func (c *LazyRefreshCache) Close() error {
+	return nil
+}
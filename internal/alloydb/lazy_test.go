@@ -0,0 +1,206 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alloydb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
+	"cloud.google.com/go/alloydbconn/internal/mock"
+	"google.golang.org/api/option"
+)
+
+func TestLazyRefreshCacheConnectInfo(t *testing.T) {
+	ctx := context.Background()
+	wantAddr := "10.0.0.1"
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithIPAddr(wantAddr),
+	)
+	// Only one call to each endpoint is expected: the second call to
+	// ConnectInfo should use the cached result.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewLazyRefreshCache(testInstanceURI(), nullLogger{}, c, RSAKey, 30*time.Second, "dialer-id")
+
+	for n := 0; n < 2; n++ {
+		gotAddr, _, err := i.ConnectInfo(ctx, PrivateIP)
+		if err != nil {
+			t.Fatalf("failed to retrieve connect info: %v", err)
+		}
+		if gotAddr != wantAddr {
+			t.Fatalf(
+				"ConnectInfo returned unexpected IP address, want = %v, got = %v",
+				wantAddr, gotAddr,
+			)
+		}
+	}
+}
+
+func TestLazyRefreshCacheForceRefresh(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewLazyRefreshCache(testInstanceURI(), nullLogger{}, c, RSAKey, 30*time.Second, "dialer-id")
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	i.ForceRefresh()
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+}
+
+func TestLazyRefreshCacheDoesNotRefreshInBackground(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewLazyRefreshCache(testInstanceURI(), nullLogger{}, c, RSAKey, 30*time.Second, "dialer-id")
+	defer i.Close()
+
+	// Give any (unexpected) background refresh a chance to run.
+	time.Sleep(100 * time.Millisecond)
+
+	// No API calls should have been made without a call to ConnectInfo.
+	if err := cleanup(); err == nil {
+		t.Fatal("expected no API calls, but all mocked calls were made")
+	}
+}
+
+func TestLazyRefreshCacheForceRefreshRefetchesMetadata(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// Even with a metadata TTL, a forced refresh should fetch the instance
+	// metadata again.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewLazyRefreshCache(
+		testInstanceURI(), nullLogger{}, c, RSAKey, 30*time.Second, "dialer-id",
+		WithMetadataTTL(time.Hour),
+	)
+
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	i.ForceRefresh()
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+}
+
+func TestLazyRefreshCacheIsValid(t *testing.T) {
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+		option.WithTokenSource(stubTokenSource{}),
+	)
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	i := NewLazyRefreshCache(
+		testInstanceURI(), nullLogger{},
+		c, RSAKey, 30*time.Second, "dialer-id",
+	)
+	defer i.Close()
+
+	if i.IsValid() {
+		t.Fatal("want IsValid = false before the first refresh, got true")
+	}
+	if _, _, err := i.ConnectInfo(ctx, PrivateIP); err != nil {
+		t.Fatalf("failed to retrieve connect info: %v", err)
+	}
+	if !i.IsValid() {
+		t.Fatal("want IsValid = true after the first refresh, got false")
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alloydb
+
+import (
+	"context"
+	"crypto/x509"
+	"math/rand"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// An Option configures optional behavior of an Instance or a
+// LazyRefreshCache.
+type Option func(*refreshConfig)
+
+type refreshConfig struct {
+	// errHandler is called whenever a background refresh fails.
+	errHandler func(instance string, err error)
+	// metadataTTL is how long instance metadata is reused across refreshes.
+	// If zero, metadata is fetched on every refresh.
+	metadataTTL time.Duration
+	// refreshBuffer is the amount of time before the certificate expires
+	// that a refresh begins.
+	refreshBuffer time.Duration
+	// refreshInterval and refreshBurst configure the rate limiter that
+	// limits how often an Instance refreshes.
+	refreshInterval time.Duration
+	refreshBurst    int
+	// noRateLimit disables the rate limiter.
+	noRateLimit bool
+	// jitter is the fraction by which the time until the next refresh is
+	// randomly adjusted. If zero, refreshes are not jittered.
+	jitter float64
+	// failureBackoffMin and failureBackoffMax bound the delay before retrying
+	// after consecutive failed refreshes. If zero, a failed refresh is
+	// retried immediately.
+	failureBackoffMin time.Duration
+	failureBackoffMax time.Duration
+	// randFloat is the source of randomness for jitter.
+	randFloat func() float64
+	// clock provides the time for the refresh cycle.
+	clock clock
+	// retryAttempts is the maximum number of attempts of each Admin API
+	// call. If zero, the client library's default retry policy is used.
+	retryAttempts int
+	// rootCAs, if set, replaces the instance's CA when verifying the server
+	// side proxy's certificate.
+	rootCAs *x509.CertPool
+	// refreshSem, if set, bounds the number of concurrent refreshes across
+	// every Instance that shares it.
+	refreshSem chan struct{}
+	// refreshBudget, if set, caps the rate of refreshes across every
+	// Instance that shares it.
+	refreshBudget *rate.Limiter
+	// refreshCount and refreshErrCount, if set, count the refreshes and the
+	// failed refreshes of every Instance that shares them.
+	refreshCount    *uint64
+	refreshErrCount *uint64
+	// csrTemplate, if set, modifies the certificate signing request sent to
+	// the AlloyDB Admin API.
+	csrTemplate func(*x509.CertificateRequest)
+	// onEvent, if set, is called at each stage of a refresh.
+	onEvent func(RefreshEvent)
+	// minTLSVersion, if non-zero, is the minimum TLS version of connections.
+	minTLSVersion uint16
+	// cipherSuites, if set, restricts the TLS 1.2 cipher suites.
+	cipherSuites []uint16
+	// ctx bounds the lifetime of an Instance's refresh cycle.
+	ctx context.Context
+	// refreshFunc, if set, replaces the AlloyDB Admin API as the source of
+	// connection info.
+	refreshFunc RefreshFunc
+}
+
+func newRefreshConfig(opts ...Option) refreshConfig {
+	cfg := refreshConfig{
+		refreshBuffer:   refreshBuffer,
+		refreshInterval: RefreshInterval,
+		refreshBurst:    RefreshBurst,
+		randFloat:       rand.Float64,
+		clock:           realClock{},
+		ctx:             context.Background(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithRefreshErrorHandler configures a function that is called with the
+// instance URI and the error whenever a background refresh fails, including
+// when the current connection info is still valid and continues to be used.
+// The handler is called from the refresh goroutine and should return quickly.
+func WithRefreshErrorHandler(h func(instance string, err error)) Option {
+	return func(c *refreshConfig) {
+		c.errHandler = h
+	}
+}
+
+// WithMetadataTTL configures how long the instance metadata (e.g., IP
+// addresses) is reused across refreshes before it is fetched again. Forced
+// refreshes always fetch fresh metadata. By default, metadata is fetched on
+// every refresh.
+func WithMetadataTTL(ttl time.Duration) Option {
+	return func(c *refreshConfig) {
+		c.metadataTTL = ttl
+	}
+}
+
+// WithRefreshBuffer configures the amount of time before the client
+// certificate expires that a new refresh begins. The buffer should be smaller
+// than CertLifetime. Defaults to 4 minutes.
+func WithRefreshBuffer(d time.Duration) Option {
+	return func(c *refreshConfig) {
+		c.refreshBuffer = d
+	}
+}
+
+// WithRefreshRateLimit configures the rate limiter of an Instance to allow a
+// refresh every interval, with bursts of up to burst refreshes. Defaults to
+// one refresh every 30 seconds with a burst of 2.
+func WithRefreshRateLimit(interval time.Duration, burst int) Option {
+	return func(c *refreshConfig) {
+		c.refreshInterval = interval
+		c.refreshBurst = burst
+	}
+}
+
+// WithNoRefreshRateLimit disables the rate limiter of an Instance, so that
+// refreshes are never throttled. It takes precedence over
+// WithRefreshRateLimit.
+func WithNoRefreshRateLimit() Option {
+	return func(c *refreshConfig) {
+		c.noRateLimit = true
+	}
+}
+
+// WithRefreshJitter configures an Instance to randomly adjust the time until
+// each refresh by up to +/- fraction of that time (e.g., 0.1 for 10%), so that
+// many instances created at the same time don't refresh in lockstep. A refresh
+// is never delayed past the refresh buffer. By default, refreshes are not
+// jittered.
+func WithRefreshJitter(fraction float64) Option {
+	return func(c *refreshConfig) {
+		c.jitter = fraction
+	}
+}
+
+// WithRefreshFailureBackoff configures an Instance to delay the retry after a
+// failed refresh by min, doubling the delay after each consecutive failure up
+// to max. A successful refresh resets the delay. Forced refreshes are not
+// delayed. By default, a failed refresh is retried immediately, subject only
+// to the rate limiter.
+func WithRefreshFailureBackoff(min, max time.Duration) Option {
+	return func(c *refreshConfig) {
+		c.failureBackoffMin = min
+		c.failureBackoffMax = max
+	}
+}
+
+// WithRefreshRetry configures each Admin API call made during a refresh to be
+// attempted up to maxAttempts times, with exponential backoff between
+// attempts, if it fails with a transient error (e.g., 503 Service
+// Unavailable). Other errors (e.g., 403 Permission Denied or 404 Not Found)
+// fail immediately. Retries stop once the refresh's context is done. By
+// default, the client library's retry policy is used, which retries only
+// 503 Service Unavailable until the context is done.
+func WithRefreshRetry(maxAttempts int) Option {
+	return func(c *refreshConfig) {
+		c.retryAttempts = maxAttempts
+	}
+}
+
+// WithRootCAs configures the pool of CA certificates used to verify the
+// server side proxy's certificate in place of the CA certificate returned by
+// the AlloyDB Admin API. It is intended for tests and private deployments
+// whose server side proxy presents a certificate signed by another CA.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *refreshConfig) {
+		c.rootCAs = pool
+	}
+}
+
+// WithRefreshSemaphore configures an Instance to hold a slot of sem while each
+// refresh runs, so that the capacity of sem bounds the number of concurrent
+// refreshes across every Instance sharing it. A refresh waiting for a slot
+// fails once its context is done.
+func WithRefreshSemaphore(sem chan struct{}) Option {
+	return func(c *refreshConfig) {
+		c.refreshSem = sem
+	}
+}
+
+// WithRefreshBudget configures an Instance to take a token from l before each
+// refresh, so that l caps the rate of refreshes across every Instance sharing
+// it. A refresh that finds no token available fails immediately with a
+// RefreshError wrapping ErrRefreshBudgetExhausted rather than waiting.
+func WithRefreshBudget(l *rate.Limiter) Option {
+	return func(c *refreshConfig) {
+		c.refreshBudget = l
+	}
+}
+
+// WithRefreshCounters configures an Instance to atomically increment
+// refreshes after each refresh, and failures after each failed refresh, so
+// that the counters aggregate the refreshes of every Instance sharing them.
+func WithRefreshCounters(refreshes, failures *uint64) Option {
+	return func(c *refreshConfig) {
+		c.refreshCount = refreshes
+		c.refreshErrCount = failures
+	}
+}
+
+// WithCSRTemplate configures an Instance to send a certificate signing
+// request for its key, created from a template modified by f, with each
+// request for an ephemeral certificate.
+func WithCSRTemplate(f func(*x509.CertificateRequest)) Option {
+	return func(c *refreshConfig) {
+		c.csrTemplate = f
+	}
+}
+
+// WithRefreshEvents configures a function that is called with an event at
+// each stage of a refresh (see RefreshEventType). It's called from the
+// refresh goroutine, possibly while holding locks of the Instance, so it must
+// return quickly and must not call the Instance.
+func WithRefreshEvents(f func(RefreshEvent)) Option {
+	return func(c *refreshConfig) {
+		c.onEvent = f
+	}
+}
+
+// WithMinTLSVersion configures the minimum TLS version (e.g.,
+// tls.VersionTLS12) of the TLS config returned by ConnectInfo. Defaults to
+// TLS 1.3.
+func WithMinTLSVersion(v uint16) Option {
+	return func(c *refreshConfig) {
+		c.minTLSVersion = v
+	}
+}
+
+// WithCipherSuites configures the cipher suites of the TLS config returned by
+// ConnectInfo. They only apply to TLS 1.2 connections. By default, Go's
+// default cipher suites are used.
+func WithCipherSuites(cs []uint16) Option {
+	return func(c *refreshConfig) {
+		c.cipherSuites = cs
+	}
+}
+
+// WithContext configures the context that bounds the lifetime of an
+// Instance's refresh cycle. Once ctx is done, an in-progress refresh is
+// canceled and no further refreshes are scheduled, as when the Instance is
+// closed. Defaults to context.Background().
+func WithContext(ctx context.Context) Option {
+	return func(c *refreshConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithRefreshFunc configures an Instance to retrieve its connection info with f
+// instead of the AlloyDB Admin API. It is intended for tests.
+func WithRefreshFunc(f RefreshFunc) Option {
+	return func(c *refreshConfig) {
+		c.refreshFunc = f
+	}
+}
+
+// withRandSource replaces the source of randomness used for jitter. It is
+// intended for tests.
+func withRandSource(f func() float64) Option {
+	return func(c *refreshConfig) {
+		c.randFloat = f
+	}
+}
+
+// withClock replaces the clock used by the refresh cycle. It is intended for
+// tests.
+func withClock(c clock) Option {
+	return func(cfg *refreshConfig) {
+		cfg.clock = c
+	}
+}
@@ -17,25 +17,40 @@ package alloydb
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	alloydbadmin "cloud.google.com/go/alloydb/apiv1beta"
-	"cloud.google.com/go/alloydb/apiv1beta/alloydbpb"
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
+	"cloud.google.com/go/alloydb/apiv1alpha/alloydbpb"
 	"cloud.google.com/go/alloydbconn/errtype"
 	"cloud.google.com/go/alloydbconn/internal/trace"
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+const (
+	// PrivateIP is the value for private IP connections.
+	PrivateIP = "PRIVATE"
+	// PublicIP is the value for public IP connections.
+	PublicIP = "PUBLIC"
+)
+
 type connectInfo struct {
-	// ipAddr is the instance's IP addresses
-	ipAddr string
+	// ipAddrs is the instance's IP addresses keyed by IP type
+	ipAddrs map[string]string
 	// uid is the instance UID
 	uid string
 }
@@ -43,24 +58,64 @@ type connectInfo struct {
 // fetchMetadata uses the AlloyDB Admin APIs get method to retrieve the
 // information about an AlloyDB instance that is used to create secure
 // connections.
-func fetchMetadata(ctx context.Context, cl *alloydbadmin.AlloyDBAdminClient, inst InstanceURI) (i connectInfo, err error) {
+func fetchMetadata(ctx context.Context, cl *alloydbadmin.AlloyDBAdminClient, inst InstanceURI, opts ...gax.CallOption) (i connectInfo, err error) {
 	var end trace.EndSpanFunc
 	ctx, end = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn/internal.FetchMetadata")
 	defer func() { end(err) }()
 	req := &alloydbpb.GetConnectionInfoRequest{
-		Parent: fmt.Sprintf(
-			"projects/%s/locations/%s/clusters/%s/instances/%s", inst.project, inst.region, inst.cluster, inst.name,
-		),
+		Parent: inst.URI(),
+	}
+	resp, err := cl.GetConnectionInfo(ctx, req, opts...)
+	if isNotFound(err) {
+		return connectInfo{}, errtype.NewNotFoundError("instance does not exist", inst.String(), err)
 	}
-	resp, err := cl.GetConnectionInfo(ctx, req)
 	if err != nil {
 		return connectInfo{}, errtype.NewRefreshError("failed to get instance metadata", inst.String(), err)
 	}
-	return connectInfo{ipAddr: resp.IpAddress, uid: resp.InstanceUid}, nil
+	// An empty private IP address is kept so that dialing it reports a clear
+	// error. A public IP address is only reported for instances that have
+	// public IP enabled.
+	ipAddrs := map[string]string{PrivateIP: resp.IpAddress}
+	if resp.PublicIpAddress != "" {
+		ipAddrs[PublicIP] = resp.PublicIpAddress
+	}
+	return connectInfo{ipAddrs: ipAddrs, uid: resp.InstanceUid}, nil
+}
+
+// fetchEngineVersion uses the AlloyDB Admin API's cluster get method to
+// retrieve the database version of the cluster the instance belongs to.
+func fetchEngineVersion(ctx context.Context, cl *alloydbadmin.AlloyDBAdminClient, inst InstanceURI) (v string, err error) {
+	var end trace.EndSpanFunc
+	ctx, end = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn/internal.FetchEngineVersion")
+	defer func() { end(err) }()
+	req := &alloydbpb.GetClusterRequest{
+		Name: fmt.Sprintf(
+			"projects/%s/locations/%s/clusters/%s", inst.project, inst.region, inst.cluster,
+		),
+	}
+	resp, err := cl.GetCluster(ctx, req)
+	if isNotFound(err) {
+		return "", errtype.NewNotFoundError("cluster does not exist", inst.String(), err)
+	}
+	if err != nil {
+		return "", errtype.NewRefreshError("failed to get cluster metadata", inst.String(), err)
+	}
+	return resp.GetDatabaseVersion().String(), nil
+}
+
+// isNotFound reports whether err is an AlloyDB Admin API error reporting that
+// the requested resource does not exist.
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
 }
 
 var errInvalidPEM = errors.New("certificate is not a valid PEM")
 
+// ErrRefreshBudgetExhausted is wrapped by the RefreshError of a refresh that
+// exceeded the budget configured with WithRefreshBudget.
+var ErrRefreshBudgetExhausted = errors.New("refresh budget exhausted")
+
 func parseCert(cert string) (*x509.Certificate, error) {
 	b, _ := pem.Decode([]byte(cert))
 	if b == nil {
@@ -77,6 +132,8 @@ func fetchEphemeralCert(
 	cl *alloydbadmin.AlloyDBAdminClient,
 	inst InstanceURI,
 	key *rsa.PrivateKey,
+	csrTemplate func(*x509.CertificateRequest),
+	opts ...gax.CallOption,
 ) (cc *certs, err error) {
 	var end trace.EndSpanFunc
 	ctx, end = trace.StartSpan(ctx, "cloud.google.com/go/alloydbconn/internal.FetchEphemeralCert")
@@ -96,7 +153,17 @@ func fetchEphemeralCert(
 		CertDuration:        durationpb.New(time.Second * 3600),
 		UseMetadataExchange: true,
 	}
-	resp, err := cl.GenerateClientCertificate(ctx, req)
+	if csrTemplate != nil {
+		csr, err := createCSR(key, csrTemplate)
+		if err != nil {
+			return nil, errtype.NewRefreshError("create ephemeral cert failed", inst.String(), err)
+		}
+		req.PemCsr = csr
+	}
+	resp, err := cl.GenerateClientCertificate(ctx, req, opts...)
+	if isNotFound(err) {
+		return nil, errtype.NewNotFoundError("cluster does not exist", inst.String(), err)
+	}
 	if err != nil {
 		return nil, errtype.NewRefreshError(
 			"create ephemeral cert failed",
@@ -162,15 +229,100 @@ func fetchEphemeralCert(
 	}, nil
 }
 
-// newRefresher creates a Refresher.
+// createCSR returns a PEM encoded certificate signing request for key, created
+// from a template that f may modify. The CSR must remain signed with and for
+// key, so f may neither replace the public key nor select a signature
+// algorithm that key can't produce.
+func createCSR(key *rsa.PrivateKey, f func(*x509.CertificateRequest)) (string, error) {
+	tmpl := &x509.CertificateRequest{SignatureAlgorithm: x509.SHA256WithRSA}
+	f(tmpl)
+	if tmpl.PublicKey != nil {
+		pub, ok := tmpl.PublicKey.(*rsa.PublicKey)
+		if !ok || !pub.Equal(&key.PublicKey) {
+			return "", errors.New("CSR template must not replace the public key")
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return "", fmt.Errorf("invalid CSR template: %w", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := pem.Encode(buf, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// retryCodes are the HTTP status codes of transient Admin API errors.
+var retryCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryBackoff is the backoff between attempts of a failed Admin API call.
+var retryBackoff = gax.Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        5 * time.Second,
+	Multiplier: 2,
+}
+
+// attemptRetryer limits the number of attempts of a call retried by the
+// embedded Retryer.
+type attemptRetryer struct {
+	gax.Retryer
+	attempts    int
+	maxAttempts int
+}
+
+func (r *attemptRetryer) Retry(err error) (time.Duration, bool) {
+	r.attempts++
+	if r.attempts >= r.maxAttempts {
+		return 0, false
+	}
+	return r.Retryer.Retry(err)
+}
+
+// newRefresher creates a Refresher configured by cfg. If cfg.metadataTTL is
+// greater than zero, the instance metadata is cached for that duration and
+// reused across refreshes. If cfg.retryAttempts is greater than zero, each
+// Admin API call is attempted up to that many times when it fails with a
+// transient error. If cfg.rootCAs is non-nil, it is used to verify the server
+// instead of the instance's CA. If cfg.refreshSem is non-nil, each refresh
+// holds one of its slots while it runs. If cfg.refreshBudget is non-nil, each
+// refresh takes one of its tokens or fails.
 func newRefresher(
 	client *alloydbadmin.AlloyDBAdminClient,
 	dialerID string,
+	cfg refreshConfig,
 ) refresher {
-	return refresher{
-		client:   client,
-		dialerID: dialerID,
+	r := refresher{
+		client:        client,
+		dialerID:      dialerID,
+		rootCAs:       cfg.rootCAs,
+		sem:           cfg.refreshSem,
+		budget:        cfg.refreshBudget,
+		count:         cfg.refreshCount,
+		errCount:      cfg.refreshErrCount,
+		csrTemplate:   cfg.csrTemplate,
+		minTLSVersion: cfg.minTLSVersion,
+		cipherSuites:  cfg.cipherSuites,
+		refreshFunc:   cfg.refreshFunc,
 	}
+	if cfg.metadataTTL > 0 {
+		r.md = &metadataCache{ttl: cfg.metadataTTL}
+	}
+	if cfg.retryAttempts > 0 {
+		r.callOpts = []gax.CallOption{gax.WithRetry(func() gax.Retryer {
+			return &attemptRetryer{
+				Retryer:     gax.OnHTTPCodes(retryBackoff, retryCodes...),
+				maxAttempts: cfg.retryAttempts,
+			}
+		})}
+	}
+	return r
 }
 
 // refresher manages the AlloyDB Admin API access to instance metadata and to
@@ -181,12 +333,174 @@ type refresher struct {
 
 	// dialerID is the unique ID of the associated dialer.
 	dialerID string
+
+	// md caches the instance metadata between refreshes. If nil, the
+	// metadata is fetched on every refresh.
+	md *metadataCache
+
+	// callOpts are applied to every Admin API call.
+	callOpts []gax.CallOption
+
+	// rootCAs, if non-nil, replaces the instance's CA when verifying the
+	// server.
+	rootCAs *x509.CertPool
+
+	// sem, if non-nil, bounds the number of concurrent refreshes across all
+	// refreshers that share it.
+	sem chan struct{}
+
+	// budget, if non-nil, caps the rate of refreshes across all refreshers
+	// that share it.
+	budget *rate.Limiter
+
+	// count and errCount, if non-nil, are incremented after each refresh
+	// and each failed refresh, respectively.
+	count    *uint64
+	errCount *uint64
+
+	// csrTemplate, if non-nil, modifies the certificate signing request
+	// sent with each request for an ephemeral certificate.
+	csrTemplate func(*x509.CertificateRequest)
+
+	// minTLSVersion, if non-zero, replaces TLS 1.3 as the minimum TLS version
+	// of connections to the server side proxy.
+	minTLSVersion uint16
+
+	// cipherSuites, if non-nil, restricts the TLS 1.2 cipher suites of
+	// connections to the server side proxy.
+	cipherSuites []uint16
+
+	// refreshFunc, if non-nil, retrieves the connection info in place of the
+	// AlloyDB Admin API.
+	refreshFunc RefreshFunc
+}
+
+// metadataCache holds the most recently fetched instance metadata so that the
+// metadata can be refreshed less often than the ephemeral certificate.
+type metadataCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	info      connectInfo
+	fetchedAt time.Time
+}
+
+// get returns the cached metadata if it is present and has not expired.
+func (m *metadataCache) get(now time.Time) (connectInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fetchedAt.IsZero() || now.Sub(m.fetchedAt) >= m.ttl {
+		return connectInfo{}, false
+	}
+	return m.info, true
+}
+
+func (m *metadataCache) set(info connectInfo, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.info = info
+	m.fetchedAt = now
+}
+
+// invalidate discards the cached metadata, e.g., when the instance's IP
+// address may have changed.
+func (m *metadataCache) invalidate() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetchedAt = time.Time{}
 }
 
 type refreshResult struct {
-	instanceIPAddr string
-	conf           *tls.Config
-	expiry         time.Time
+	// ipAddrs is the instance's IP addresses keyed by IP type.
+	ipAddrs map[string]string
+	conf    *tls.Config
+	expiry  time.Time
+}
+
+// addr returns the instance's address for the requested IP type along with a
+// TLS configuration that verifies the server against that address. The TLS
+// configuration is a clone that shares only the certificates with the cached
+// one, so callers may modify it. If the instance has no address of the requested type, addr returns a ConfigError.
+// If the address is reported but empty (e.g., the instance is still being
+// provisioned), addr returns a DialError.
+func (r refreshResult) addr(inst InstanceURI, ipType string) (string, *tls.Config, error) {
+	addr, ok := r.ipAddrs[ipType]
+	if !ok {
+		return "", nil, errtype.NewConfigError(
+			fmt.Sprintf("instance does not have IP of type %q", ipType),
+			inst.String(),
+		)
+	}
+	if addr == "" {
+		return "", nil, errtype.NewDialError(
+			fmt.Sprintf("instance has no provisioned IP address of type %q", ipType),
+			inst.String(),
+			nil,
+		)
+	}
+	c := r.conf.Clone()
+	c.ServerName = addr
+	return addr, c, nil
+}
+
+// CertificateInfo describes the client certificate used to connect to an
+// instance, e.g., for auditing. It never includes the private key.
+type CertificateInfo struct {
+	// Subject is the certificate's subject as an RFC 2253 distinguished name.
+	Subject string
+	// DNSNames, EmailAddresses, IPAddresses, and URIs are the certificate's
+	// subject alternative names.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []string
+	// NotBefore and NotAfter bound the certificate's validity window.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// certInfo returns the details of the cached client certificate. If the
+// certificate's Leaf hasn't been parsed, it's parsed from the first
+// certificate of the chain.
+func (r refreshResult) certInfo(inst InstanceURI) (CertificateInfo, error) {
+	if r.conf == nil || len(r.conf.Certificates) == 0 {
+		return CertificateInfo{}, errtype.NewRefreshError(
+			"no client certificate", inst.String(), nil,
+		)
+	}
+	cert := r.conf.Certificates[0]
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return CertificateInfo{}, errtype.NewRefreshError(
+				"no client certificate", inst.String(), nil,
+			)
+		}
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return CertificateInfo{}, errtype.NewRefreshError(
+				"parse client certificate failed", inst.String(), err,
+			)
+		}
+	}
+	info := CertificateInfo{
+		Subject:        leaf.Subject.String(),
+		DNSNames:       append([]string(nil), leaf.DNSNames...),
+		EmailAddresses: append([]string(nil), leaf.EmailAddresses...),
+		NotBefore:      leaf.NotBefore,
+		NotAfter:       leaf.NotAfter,
+	}
+	for _, ip := range leaf.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, append(net.IP(nil), ip...))
+	}
+	for _, u := range leaf.URIs {
+		info.URIs = append(info.URIs, u.String())
+	}
+	return info, nil
 }
 
 type certs struct {
@@ -201,10 +515,35 @@ func (r refresher) performRefresh(ctx context.Context, cn InstanceURI, k *rsa.Pr
 		trace.AddInstanceName(cn.String()),
 	)
 	defer func() {
+		if r.count != nil {
+			atomic.AddUint64(r.count, 1)
+		}
+		if err != nil && r.errCount != nil {
+			atomic.AddUint64(r.errCount, 1)
+		}
 		go trace.RecordRefreshResult(context.Background(), cn.String(), r.dialerID, err)
 		refreshEnd(err)
 	}()
 
+	if r.budget != nil && !r.budget.Allow() {
+		return refreshResult{}, errtype.NewRefreshError(
+			"refresh failed", cn.String(), ErrRefreshBudgetExhausted,
+		)
+	}
+
+	if r.sem != nil {
+		select {
+		case r.sem <- struct{}{}:
+			defer func() { <-r.sem }()
+		case <-ctx.Done():
+			return refreshResult{}, fmt.Errorf("refresh failed: %w", ctx.Err())
+		}
+	}
+
+	if r.refreshFunc != nil {
+		return r.refreshWithFunc(ctx, cn, k)
+	}
+
 	type mdRes struct {
 		info connectInfo
 		err  error
@@ -212,7 +551,16 @@ func (r refresher) performRefresh(ctx context.Context, cn InstanceURI, k *rsa.Pr
 	mdCh := make(chan mdRes, 1)
 	go func() {
 		defer close(mdCh)
-		c, err := fetchMetadata(ctx, r.client, cn)
+		if r.md != nil {
+			if c, ok := r.md.get(time.Now()); ok {
+				mdCh <- mdRes{info: c}
+				return
+			}
+		}
+		c, err := fetchMetadata(ctx, r.client, cn, r.callOpts...)
+		if err == nil && r.md != nil {
+			r.md.set(c, time.Now())
+		}
 		mdCh <- mdRes{info: c, err: err}
 	}()
 
@@ -223,7 +571,7 @@ func (r refresher) performRefresh(ctx context.Context, cn InstanceURI, k *rsa.Pr
 	certCh := make(chan certRes, 1)
 	go func() {
 		defer close(certCh)
-		cc, err := fetchEphemeralCert(ctx, r.client, cn, k)
+		cc, err := fetchEphemeralCert(ctx, r.client, cn, k, r.csrTemplate, r.callOpts...)
 		certCh <- certRes{cc: cc, err: err}
 	}()
 
@@ -249,14 +597,76 @@ func (r refresher) performRefresh(ctx context.Context, cn InstanceURI, k *rsa.Pr
 		return refreshResult{}, fmt.Errorf("refresh failed: %w", ctx.Err())
 	}
 
-	caCerts := x509.NewCertPool()
-	caCerts.AddCert(cc.caCert)
+	caCerts := r.rootCAs
+	if caCerts == nil {
+		caCerts = x509.NewCertPool()
+		caCerts.AddCert(cc.caCert)
+	}
+	c := r.tlsConfig(cc.certChain, caCerts)
+
+	return refreshResult{ipAddrs: info.ipAddrs, conf: c, expiry: cc.expiry}, nil
+}
+
+// tlsConfig returns the TLS configuration that presents cert to the server
+// side proxy and verifies it with caCerts. Unless configured otherwise, it
+// requires TLS 1.3.
+func (r refresher) tlsConfig(cert tls.Certificate, caCerts *x509.CertPool) *tls.Config {
 	c := &tls.Config{
-		Certificates: []tls.Certificate{cc.certChain},
+		Certificates: []tls.Certificate{cert},
 		RootCAs:      caCerts,
-		ServerName:   info.ipAddr,
 		MinVersion:   tls.VersionTLS13,
+		CipherSuites: r.cipherSuites,
 	}
+	if r.minTLSVersion != 0 {
+		c.MinVersion = r.minTLSVersion
+	}
+	return c
+}
+
+// RefreshData is the connection info of an instance returned by a
+// RefreshFunc.
+type RefreshData struct {
+	// IPAddrs maps IP types (e.g., PrivateIP) to the instance's address of
+	// that type.
+	IPAddrs map[string]string
+	// ClientCert is the client certificate presented to the server side
+	// proxy. If its Leaf is nil, it is parsed from the first certificate of
+	// the chain.
+	ClientCert tls.Certificate
+	// RootCAs verifies the server side proxy's certificate.
+	RootCAs *x509.CertPool
+	// Expiry is the expiration time of ClientCert.
+	Expiry time.Time
+}
+
+// RefreshFunc retrieves the connection info of an instance, e.g., to replace
+// the AlloyDB Admin API in tests. The client certificate must be issued for
+// the public key of k.
+type RefreshFunc func(ctx context.Context, inst InstanceURI, k *rsa.PrivateKey) (RefreshData, error)
 
-	return refreshResult{instanceIPAddr: info.ipAddr, conf: c, expiry: cc.expiry}, nil
+// refreshWithFunc performs a refresh with the refresher's refreshFunc.
+func (r refresher) refreshWithFunc(ctx context.Context, cn InstanceURI, k *rsa.PrivateKey) (refreshResult, error) {
+	d, err := r.refreshFunc(ctx, cn, k)
+	if err != nil {
+		return refreshResult{}, errtype.NewRefreshError("refresh failed", cn.String(), err)
+	}
+	cert := d.ClientCert
+	if cert.Leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return refreshResult{}, errtype.NewRefreshError(
+				"refresh failed", cn.String(), errors.New("no client certificate"),
+			)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return refreshResult{}, errtype.NewRefreshError("refresh failed", cn.String(), err)
+		}
+		cert.Leaf = leaf
+	}
+	caCerts := r.rootCAs
+	if caCerts == nil {
+		caCerts = d.RootCAs
+	}
+	c := r.tlsConfig(cert, caCerts)
+	return refreshResult{ipAddrs: d.IPAddrs, conf: c, expiry: d.Expiry}, nil
 }
@@ -16,12 +16,17 @@ package alloydb
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
-	alloydbadmin "cloud.google.com/go/alloydb/apiv1beta"
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
+	"cloud.google.com/go/alloydbconn/errtype"
 	"cloud.google.com/go/alloydbconn/internal/mock"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
@@ -58,13 +63,13 @@ func TestRefresh(t *testing.T) {
 	if err != nil {
 		t.Fatalf("admin API client error: %v", err)
 	}
-	r := newRefresher(cl, testDialerID)
+	r := newRefresher(cl, testDialerID, newRefreshConfig())
 	res, err := r.performRefresh(context.Background(), cn, RSAKey)
 	if err != nil {
 		t.Fatalf("performRefresh unexpectedly failed with error: %v", err)
 	}
 
-	if got := res.instanceIPAddr; wantIP != got {
+	if got := res.ipAddrs[PrivateIP]; wantIP != got {
 		t.Fatalf("metadata IP mismatch, want = %v, got = %v", wantIP, got)
 	}
 	if got := res.expiry; wantExpiry != got {
@@ -72,6 +77,45 @@ func TestRefresh(t *testing.T) {
 	}
 }
 
+func TestRefreshWithPublicIP(t *testing.T) {
+	wantPublicIP := "34.0.0.1"
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+		mock.WithPublicIPAddr(wantPublicIP),
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	cl, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+		context.Background(),
+		option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("admin API client error: %v", err)
+	}
+	r := newRefresher(cl, testDialerID, newRefreshConfig())
+	res, err := r.performRefresh(context.Background(), testInstanceURI(), RSAKey)
+	if err != nil {
+		t.Fatalf("performRefresh unexpectedly failed with error: %v", err)
+	}
+
+	got, _, err := res.addr(testInstanceURI(), PublicIP)
+	if err != nil {
+		t.Fatalf("want no error, got = %v", err)
+	}
+	if got != wantPublicIP {
+		t.Fatalf("public IP mismatch, want = %v, got = %v", wantPublicIP, got)
+	}
+}
+
 func TestRefreshFailsFast(t *testing.T) {
 	wantInstURI := "/projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
 	cn, err := ParseInstURI(wantInstURI)
@@ -99,7 +143,7 @@ func TestRefreshFailsFast(t *testing.T) {
 	if err != nil {
 		t.Fatalf("admin API client error: %v", err)
 	}
-	r := newRefresher(cl, testDialerID)
+	r := newRefresher(cl, testDialerID, newRefreshConfig())
 
 	_, err = r.performRefresh(context.Background(), cn, RSAKey)
 	if err != nil {
@@ -114,3 +158,226 @@ func TestRefreshFailsFast(t *testing.T) {
 		t.Fatalf("expected context.Canceled error, got = %v", err)
 	}
 }
+
+func TestRefreshResultAddr(t *testing.T) {
+	res := refreshResult{
+		ipAddrs: map[string]string{
+			PrivateIP: "10.0.0.1",
+			PublicIP:  "34.0.0.1",
+		},
+		conf: &tls.Config{},
+	}
+	tcs := []struct {
+		ipType string
+		want   string
+	}{
+		{ipType: PrivateIP, want: "10.0.0.1"},
+		{ipType: PublicIP, want: "34.0.0.1"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.ipType, func(t *testing.T) {
+			got, c, err := res.addr(testInstanceURI(), tc.ipType)
+			if err != nil {
+				t.Fatalf("want no error, got = %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("addr mismatch, want = %v, got = %v", tc.want, got)
+			}
+			if c.ServerName != tc.want {
+				t.Fatalf("server name mismatch, want = %v, got = %v", tc.want, c.ServerName)
+			}
+		})
+	}
+	if res.conf.ServerName != "" {
+		t.Fatalf("cached TLS config was modified, got server name = %v", res.conf.ServerName)
+	}
+}
+
+func TestRefreshResultAddrWithEmptyIP(t *testing.T) {
+	res := refreshResult{
+		ipAddrs: map[string]string{PrivateIP: ""},
+		conf:    &tls.Config{},
+	}
+	_, _, err := res.addr(testInstanceURI(), PrivateIP)
+	var wantErr *errtype.DialError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("when IP address is empty, want = %T, got = %v", wantErr, err)
+	}
+	if !strings.Contains(err.Error(), "no provisioned IP address") {
+		t.Fatalf("want error to report the missing IP address, got = %v", err)
+	}
+}
+
+func TestRefreshWithMetadataTTL(t *testing.T) {
+	cn := testInstanceURI()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// The metadata should only be fetched once for both refreshes.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	cl, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+		context.Background(),
+		option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("admin API client error: %v", err)
+	}
+	r := newRefresher(cl, testDialerID, newRefreshConfig(WithMetadataTTL(time.Hour)))
+	for n := 0; n < 2; n++ {
+		res, err := r.performRefresh(context.Background(), cn, RSAKey)
+		if err != nil {
+			t.Fatalf("performRefresh unexpectedly failed with error: %v", err)
+		}
+		if got, want := res.ipAddrs[PrivateIP], "127.0.0.1"; got != want {
+			t.Fatalf("metadata IP mismatch, want = %v, got = %v", want, got)
+		}
+	}
+}
+
+func TestMetadataCacheExpires(t *testing.T) {
+	now := time.Now()
+	m := &metadataCache{ttl: time.Minute}
+	if _, ok := m.get(now); ok {
+		t.Fatal("want empty cache to miss")
+	}
+	m.set(connectInfo{uid: "some-uid"}, now)
+	if _, ok := m.get(now.Add(30 * time.Second)); !ok {
+		t.Fatal("want cache hit before TTL")
+	}
+	if _, ok := m.get(now.Add(time.Minute)); ok {
+		t.Fatal("want cache miss after TTL")
+	}
+	m.set(connectInfo{uid: "some-uid"}, now)
+	m.invalidate()
+	if _, ok := m.get(now); ok {
+		t.Fatal("want cache miss after invalidate")
+	}
+}
+
+func TestRefreshRetriesTransientErrors(t *testing.T) {
+	cn := testInstanceURI()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// Requests are matched in order, so the first two calls fail.
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetError(inst, http.StatusServiceUnavailable, 1),
+		mock.InstanceGetError(inst, http.StatusTooManyRequests, 1),
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	cl, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+		context.Background(),
+		option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("admin API client error: %v", err)
+	}
+	r := newRefresher(cl, testDialerID, newRefreshConfig(WithRefreshRetry(3)))
+
+	if _, err := r.performRefresh(context.Background(), cn, RSAKey); err != nil {
+		t.Fatalf("performRefresh unexpectedly failed with error: %v", err)
+	}
+}
+
+func TestRefreshRetryErrors(t *testing.T) {
+	tcs := []struct {
+		desc string
+		code int
+		// attempts is the number of calls the refresh should make.
+		attempts int
+	}{
+		{
+			desc:     "transient errors stop after the max attempts",
+			code:     http.StatusServiceUnavailable,
+			attempts: 2,
+		},
+		{
+			desc:     "permission denied fails fast",
+			code:     http.StatusForbidden,
+			attempts: 1,
+		},
+		{
+			desc:     "not found fails fast",
+			code:     http.StatusNotFound,
+			attempts: 1,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			inst := mock.NewFakeInstance(
+				"my-project", "my-region", "my-cluster", "my-instance",
+			)
+			// Allow for one more call than expected, to detect extra
+			// attempts.
+			get := mock.InstanceGetError(inst, tc.code, tc.attempts+1)
+			mc, url, cleanup := mock.HTTPClient(
+				get,
+				mock.CreateEphemeralSuccess(inst, 1),
+			)
+			cl, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+				context.Background(),
+				option.WithHTTPClient(mc),
+				option.WithEndpoint(url),
+			)
+			if err != nil {
+				t.Fatalf("admin API client error: %v", err)
+			}
+			r := newRefresher(cl, testDialerID, newRefreshConfig(WithRefreshRetry(2)))
+
+			_, err = r.performRefresh(context.Background(), testInstanceURI(), RSAKey)
+			var apiErr *googleapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tc.code {
+				t.Fatalf("want error with code %v, got = %v", tc.code, err)
+			}
+			// Exactly one call is left.
+			err = cleanup()
+			if err == nil || !strings.HasPrefix(err.Error(), "1 calls left") {
+				t.Fatalf("want exactly %v attempts, got cleanup error = %v", tc.attempts, err)
+			}
+		})
+	}
+}
+
+func TestRefreshReportsMissingInstance(t *testing.T) {
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	// Don't use the cleanup function. The certificate request runs
+	// concurrently and may not be made before the refresh fails.
+	mc, url, _ := mock.HTTPClient(
+		mock.InstanceGetError(inst, http.StatusNotFound, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	cl, err := alloydbadmin.NewAlloyDBAdminRESTClient(
+		context.Background(),
+		option.WithHTTPClient(mc),
+		option.WithEndpoint(url),
+	)
+	if err != nil {
+		t.Fatalf("admin API client error: %v", err)
+	}
+	r := newRefresher(cl, testDialerID, newRefreshConfig())
+
+	_, err = r.performRefresh(context.Background(), testInstanceURI(), RSAKey)
+	var wantErr *errtype.NotFoundError
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("want = %T, got = %v", wantErr, err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alloydb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/alloydbconn/errtype"
+)
+
+// StaticConnectionInfo provides fixed connection info for an instance that
+// isn't managed by the AlloyDB Admin API (e.g., AlloyDB Omni). Unlike Instance
+// and LazyRefreshCache, it never refreshes, so it makes no API calls.
+type StaticConnectionInfo struct {
+	// openConns is the number of open connections to the instance.
+	openConns uint64
+	// maxOpenConns is the highest number of open connections to the
+	// instance.
+	maxOpenConns uint64
+
+	instanceURI InstanceURI
+	addr        string
+	result      refreshResult
+}
+
+// NewStaticConnectionInfo initializes a new StaticConnectionInfo that connects
+// to addr using conf. If the client certificate of conf has no parsed Leaf, it
+// is parsed from the first certificate of the chain, so that its expiry is
+// known.
+func NewStaticConnectionInfo(instance InstanceURI, addr string, conf *tls.Config) *StaticConnectionInfo {
+	c := conf.Clone()
+	var expiry time.Time
+	if len(c.Certificates) > 0 {
+		cert := c.Certificates[0]
+		if cert.Leaf == nil && len(cert.Certificate) > 0 {
+			if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+				cert.Leaf = leaf
+				c.Certificates[0] = cert
+			}
+		}
+		if cert.Leaf != nil {
+			expiry = cert.Leaf.NotAfter
+		}
+	}
+	return &StaticConnectionInfo{
+		instanceURI: instance,
+		addr:        addr,
+		result:      refreshResult{conf: c, expiry: expiry},
+	}
+}
+
+// OpenConns reports the number of open connections.
+func (s *StaticConnectionInfo) OpenConns() *uint64 {
+	return &s.openConns
+}
+
+// MaxOpenConns reports the highest number of open connections.
+func (s *StaticConnectionInfo) MaxOpenConns() *uint64 {
+	return &s.maxOpenConns
+}
+
+// ConnectInfo returns the configured address regardless of the requested IP
+// type. As with Instance, the returned TLS config is a copy that callers may
+// modify. Unless the configured TLS config sets a ServerName, the server is
+// verified against the address.
+func (s *StaticConnectionInfo) ConnectInfo(ctx context.Context, ipType string) (string, *tls.Config, error) {
+	addr, tlsCfg, _, err := s.ConnectInfoWithExpiry(ctx, ipType)
+	return addr, tlsCfg, err
+}
+
+// ConnectInfoWithExpiry is like ConnectInfo but also returns the expiration
+// time of the client certificate, or the zero time if there is none.
+func (s *StaticConnectionInfo) ConnectInfoWithExpiry(context.Context, string) (string, *tls.Config, time.Time, error) {
+	c := s.result.conf.Clone()
+	if c.ServerName == "" {
+		c.ServerName = s.addr
+	}
+	return s.addr, c, s.result.expiry, nil
+}
+
+// EngineVersion always fails, as the version is only reported by the AlloyDB
+// Admin API.
+func (s *StaticConnectionInfo) EngineVersion(context.Context) (string, error) {
+	return "", errtype.NewConfigError(
+		"engine version is not available with static connection info",
+		s.instanceURI.String(),
+	)
+}
+
+// CertificateInfo returns the details of the configured client certificate.
+func (s *StaticConnectionInfo) CertificateInfo(context.Context) (CertificateInfo, error) {
+	return s.result.certInfo(s.instanceURI)
+}
+
+// IsValid reports whether the configured client certificate has not
+// expired. Connection info without a client certificate is always valid.
+func (s *StaticConnectionInfo) IsValid() bool {
+	return s.result.expiry.IsZero() || time.Now().Before(s.result.expiry)
+}
+
+// CurrentRefreshAge always returns zero, as the connection info never
+// changes.
+func (s *StaticConnectionInfo) CurrentRefreshAge() time.Duration {
+	return 0
+}
+
+// Health reports the configured certificate's expiry and the number of open
+// connections.
+func (s *StaticConnectionInfo) Health() Health {
+	return Health{
+		CertExpiry: s.result.expiry,
+		OpenConns:  atomic.LoadUint64(&s.openConns),
+	}
+}
+
+// ForceRefresh is a no-op.
+func (s *StaticConnectionInfo) ForceRefresh() {}
+
+// WaitForRefresh returns immediately, as there is never a refresh to wait
+// for.
+func (s *StaticConnectionInfo) WaitForRefresh(context.Context) error {
+	return nil
+}
+
+// Close is a no-op.
+func (s *StaticConnectionInfo) Close() error {
+	return nil
+}
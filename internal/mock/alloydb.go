@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"math/big"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -42,6 +43,14 @@ func WithIPAddr(addr string) Option {
 	}
 }
 
+// WithPublicIPAddr sets the public IP address of the instance. By default, the
+// instance has no public IP address.
+func WithPublicIPAddr(addr string) Option {
+	return func(f *FakeAlloyDBInstance) {
+		f.publicIPAddr = addr
+	}
+}
+
 // WithServerName sets the name that server uses to identify itself in the TLS
 // handshake.
 func WithServerName(name string) Option {
@@ -57,6 +66,22 @@ func WithCertExpiry(expiry time.Time) Option {
 	}
 }
 
+// WithDatabaseVersion sets the database version reported for the instance's
+// cluster.
+func WithDatabaseVersion(v string) Option {
+	return func(f *FakeAlloyDBInstance) {
+		f.dbVersion = v
+	}
+}
+
+// WithUntrustedServerCert configures the server side proxy to present a
+// self-signed certificate that is not signed by the instance's CA.
+func WithUntrustedServerCert() Option {
+	return func(f *FakeAlloyDBInstance) {
+		f.untrusted = true
+	}
+}
+
 // FakeAlloyDBInstance represents the server side proxy.
 type FakeAlloyDBInstance struct {
 	project string
@@ -64,10 +89,13 @@ type FakeAlloyDBInstance struct {
 	cluster string
 	name    string
 
-	ipAddr     string
-	uid        string
-	serverName string
-	certExpiry time.Time
+	ipAddr       string
+	publicIPAddr string
+	uid          string
+	serverName   string
+	certExpiry   time.Time
+	dbVersion    string
+	untrusted    bool
 
 	rootCACert *x509.Certificate
 	rootKey    *rsa.PrivateKey
@@ -77,6 +105,31 @@ type FakeAlloyDBInstance struct {
 
 	serverCert *x509.Certificate
 	serverKey  *rsa.PrivateKey
+
+	// tokens is shared by all copies of the instance, so that the server
+	// side proxy can record the tokens clients send.
+	tokens *tokenLog
+}
+
+// tokenLog records the OAuth2 tokens received in metadata exchanges.
+type tokenLog struct {
+	mu     sync.Mutex
+	tokens []string
+}
+
+func (l *tokenLog) add(tok string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = append(l.tokens, tok)
+}
+
+// OAuth2Tokens returns the OAuth2 tokens the server side proxy received in
+// metadata exchanges, in order. Exchanges without a token are recorded as an
+// empty string.
+func (f FakeAlloyDBInstance) OAuth2Tokens() []string {
+	f.tokens.mu.Lock()
+	defer f.tokens.mu.Unlock()
+	return append([]string(nil), f.tokens.tokens...)
 }
 
 func mustGenerateKey() *rsa.PrivateKey {
@@ -104,6 +157,8 @@ func NewFakeInstance(proj, reg, clust, name string, opts ...Option) FakeAlloyDBI
 		uid:        "00000000-0000-0000-0000-000000000000",
 		serverName: "00000000-0000-0000-0000-000000000000.server.alloydb",
 		certExpiry: time.Now().Add(24 * time.Hour),
+		dbVersion:  "POSTGRES_15",
+		tokens:     &tokenLog{},
 	}
 
 	for _, o := range opts {
@@ -167,9 +222,14 @@ func NewFakeInstance(proj, reg, clust, name string, opts ...Option) FakeAlloyDBI
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:              []string{f.serverName},
+	}
+	parent, parentKey := rootCert, rootCAKey
+	if f.untrusted {
+		parent, parentKey = serverTemplate, serverKey
 	}
 	signedServer, err := x509.CreateCertificate(
-		rand.Reader, serverTemplate, rootCert, &serverKey.PublicKey, rootCAKey)
+		rand.Reader, serverTemplate, parent, &serverKey.PublicKey, parentKey)
 	if err != nil {
 		panic(err)
 	}
@@ -189,11 +249,55 @@ func NewFakeInstance(proj, reg, clust, name string, opts ...Option) FakeAlloyDBI
 	return f
 }
 
+// ServerCert returns the certificate the server side proxy presents.
+func (f FakeAlloyDBInstance) ServerCert() *x509.Certificate {
+	return f.serverCert
+}
+
+// signClientCert creates a client certificate for pub signed by the
+// instance's client CA, as the AlloyDB Admin API does.
+func (f FakeAlloyDBInstance) signClientCert(pub *rsa.PublicKey) ([]byte, error) {
+	template := &x509.Certificate{
+		PublicKey:    pub,
+		SerialNumber: &big.Int{},
+		Issuer:       f.intermedCert.Subject,
+		NotBefore:    time.Now(),
+		NotAfter:     f.certExpiry,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return x509.CreateCertificate(rand.Reader, template, f.intermedCert, pub, f.intermedKey)
+}
+
+// ClientCert returns a client certificate for key that the server side proxy
+// accepts, along with a pool containing the CA that verifies the server side
+// proxy's certificate.
+func (f FakeAlloyDBInstance) ClientCert(key *rsa.PrivateKey) (tls.Certificate, *x509.CertPool, error) {
+	der, err := f.signClientCert(&key.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(f.rootCACert)
+	return tls.Certificate{
+		Certificate: [][]byte{der, f.intermedCert.Raw},
+		PrivateKey:  key,
+	}, pool, nil
+}
+
 // StartServerProxy starts a fake server proxy and listens on the provided port
 // on all interfaces, configured with TLS as specified by the
 // FakeAlloyDBInstance. Callers should invoke the returned function to clean up
 // all resources.
 func StartServerProxy(t *testing.T, inst FakeAlloyDBInstance) func() {
+	stop, _ := StartServerProxyAt(t, inst, ":5433")
+	return stop
+}
+
+// StartServerProxyAt is like StartServerProxy, but listens on the provided
+// address and returns the address the proxy is listening on. Use an address
+// with port 0 (e.g., "127.0.0.1:0") to avoid conflicts with other tests.
+func StartServerProxyAt(t *testing.T, inst FakeAlloyDBInstance, addr string) (func(), string) {
 	pool := x509.NewCertPool()
 	pool.AddCert(inst.rootCACert)
 	tryListen := func(t *testing.T, attempts int) net.Listener {
@@ -202,7 +306,7 @@ func StartServerProxy(t *testing.T, inst FakeAlloyDBInstance) func() {
 			err error
 		)
 		for i := 0; i < attempts; i++ {
-			ln, err = tls.Listen("tcp", ":5433", &tls.Config{
+			ln, err = tls.Listen("tcp", addr, &tls.Config{
 				Certificates: []tls.Certificate{
 					{
 						Certificate: [][]byte{inst.serverCert.Raw, inst.rootCACert.Raw},
@@ -236,9 +340,11 @@ func StartServerProxy(t *testing.T, inst FakeAlloyDBInstance) func() {
 				if err != nil {
 					return
 				}
-				if err := metadataExchange(conn); err != nil {
+				if err := metadataExchange(conn, inst.tokens); err != nil {
+					// e.g., the client failed the TLS handshake. Keep
+					// serving subsequent connections.
 					conn.Close()
-					return
+					continue
 				}
 
 				// Database protocol takes over from here.
@@ -250,7 +356,7 @@ func StartServerProxy(t *testing.T, inst FakeAlloyDBInstance) func() {
 	return func() {
 		cancel()
 		ln.Close()
-	}
+	}, ln.Addr().String()
 }
 
 // metadataExchange mimics server side behavior in four steps:
@@ -260,11 +366,13 @@ func StartServerProxy(t *testing.T, inst FakeAlloyDBInstance) func() {
 //     bytes.
 //
 //  2. Read the message from the client using the message length and unmarshal
-//     it into a MetadataExchangeResponse message.
+//     it into a MetadataExchangeResponse message. The OAuth2 token of the
+//     message is recorded in tokens.
 //
 // The real server implementation will then validate the client has connection
 // permissions using the provided OAuth2 token based on the auth type. Here in
-// the test implementation, the server does nothing.
+// the test implementation, the server only verifies a token is present when
+// the auth type is AUTO_IAM.
 //
 //  3. Prepare a response and write the size of the response as a uint32 (4
 //     bytes)
@@ -272,7 +380,7 @@ func StartServerProxy(t *testing.T, inst FakeAlloyDBInstance) func() {
 // 4. Marshal the response to bytes and write those to the client as well.
 //
 // Subsequent interactions with the test server use the database protocol.
-func metadataExchange(conn net.Conn) error {
+func metadataExchange(conn net.Conn, tokens *tokenLog) error {
 	msgSize := make([]byte, 4)
 	n, err := conn.Read(msgSize)
 	if err != nil {
@@ -297,10 +405,20 @@ func metadataExchange(conn net.Conn) error {
 	if err != nil {
 		return err
 	}
+	tokens.add(m.GetOauth2Token())
 
 	resp := &connectorspb.MetadataExchangeResponse{
 		ResponseCode: connectorspb.MetadataExchangeResponse_OK,
 	}
+	// With auto IAM AuthN, the server authenticates the database user with
+	// the OAuth2 token, so reject requests that do not include one.
+	if m.GetAuthType() == connectorspb.MetadataExchangeRequest_AUTO_IAM &&
+		m.GetOauth2Token() == "" {
+		resp = &connectorspb.MetadataExchangeResponse{
+			ResponseCode: connectorspb.MetadataExchangeResponse_ERROR,
+			Error:        "missing OAuth2 token for auto IAM AuthN",
+		}
+	}
 	data, err := proto.Marshal(resp)
 	if err != nil {
 		return err
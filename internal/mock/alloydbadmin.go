@@ -16,19 +16,24 @@ package mock
 
 import (
 	"bytes"
-	"crypto/rand"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
-	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
-	"time"
 
-	"cloud.google.com/go/alloydb/apiv1beta/alloydbpb"
+	"cloud.google.com/go/alloydb/apiv1alpha/alloydbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
@@ -65,7 +70,7 @@ func (r *Request) matches(hR *http.Request) bool {
 // InstanceGetSuccess returns a Request that responds to the `instance.get`
 // AlloyDB Admin API endpoint.
 func InstanceGetSuccess(i FakeAlloyDBInstance, ct int) *Request {
-	p := fmt.Sprintf("/v1beta/projects/%s/locations/%s/clusters/%s/instances/%s/connectionInfo",
+	p := fmt.Sprintf("/v1alpha/projects/%s/locations/%s/clusters/%s/instances/%s/connectionInfo",
 		i.project, i.region, i.cluster, i.name)
 	return &Request{
 		reqMethod: http.MethodGet,
@@ -73,7 +78,56 @@ func InstanceGetSuccess(i FakeAlloyDBInstance, ct int) *Request {
 		reqCt:     ct,
 		handle: func(resp http.ResponseWriter, req *http.Request) {
 			resp.WriteHeader(http.StatusOK)
-			resp.Write([]byte(fmt.Sprintf(`{"ipAddress":"%s","instanceUid":"%s"}`, i.ipAddr, i.uid)))
+			resp.Write([]byte(fmt.Sprintf(
+				`{"ipAddress":"%s","publicIpAddress":"%s","instanceUid":"%s"}`,
+				i.ipAddr, i.publicIPAddr, i.uid,
+			)))
+		},
+	}
+}
+
+// InstanceGetError returns a Request that responds to the `instance.get`
+// AlloyDB Admin API endpoint with the provided HTTP status code.
+func InstanceGetError(i FakeAlloyDBInstance, code, ct int) *Request {
+	p := fmt.Sprintf("/v1alpha/projects/%s/locations/%s/clusters/%s/instances/%s/connectionInfo",
+		i.project, i.region, i.cluster, i.name)
+	return &Request{
+		reqMethod: http.MethodGet,
+		reqPath:   p,
+		reqCt:     ct,
+		handle: func(resp http.ResponseWriter, req *http.Request) {
+			http.Error(resp, http.StatusText(code), code)
+		},
+	}
+}
+
+// ClusterGetSuccess returns a Request that responds to the `cluster.get`
+// AlloyDB Admin API endpoint.
+func ClusterGetSuccess(i FakeAlloyDBInstance, ct int) *Request {
+	p := fmt.Sprintf("/v1alpha/projects/%s/locations/%s/clusters/%s",
+		i.project, i.region, i.cluster)
+	return &Request{
+		reqMethod: http.MethodGet,
+		reqPath:   p,
+		reqCt:     ct,
+		handle: func(resp http.ResponseWriter, req *http.Request) {
+			resp.WriteHeader(http.StatusOK)
+			resp.Write([]byte(fmt.Sprintf(`{"databaseVersion":"%s"}`, i.dbVersion)))
+		},
+	}
+}
+
+// ClusterGetError returns a Request that responds to the `cluster.get`
+// AlloyDB Admin API endpoint with the provided HTTP status code.
+func ClusterGetError(i FakeAlloyDBInstance, code, ct int) *Request {
+	p := fmt.Sprintf("/v1alpha/projects/%s/locations/%s/clusters/%s",
+		i.project, i.region, i.cluster)
+	return &Request{
+		reqMethod: http.MethodGet,
+		reqPath:   p,
+		reqCt:     ct,
+		handle: func(resp http.ResponseWriter, req *http.Request) {
+			http.Error(resp, http.StatusText(code), code)
 		},
 	}
 }
@@ -84,7 +138,7 @@ func CreateEphemeralSuccess(i FakeAlloyDBInstance, ct int) *Request {
 	return &Request{
 		reqMethod: http.MethodPost,
 		reqPath: fmt.Sprintf(
-			"/v1beta/projects/%s/locations/%s/clusters/%s:generateClientCertificate",
+			"/v1alpha/projects/%s/locations/%s/clusters/%s:generateClientCertificate",
 			i.project, i.region, i.cluster),
 		reqCt: ct,
 		handle: func(resp http.ResponseWriter, req *http.Request) {
@@ -101,48 +155,12 @@ func CreateEphemeralSuccess(i FakeAlloyDBInstance, ct int) *Request {
 				http.Error(resp, fmt.Errorf("invalid or unexpected json: %w", err).Error(), http.StatusBadRequest)
 				return
 			}
-			bl, _ := pem.Decode([]byte(rreq.PublicKey))
-			if bl == nil {
-				http.Error(resp, fmt.Errorf("unable to decode CSR: %w", err).Error(), http.StatusBadRequest)
-				return
-			}
-			pub, err := x509.ParsePKCS1PublicKey(bl.Bytes)
-			if err != nil {
-				http.Error(resp, fmt.Errorf("unable to decode CSR: %w", err).Error(), http.StatusBadRequest)
-				return
-			}
-
-			template := &x509.Certificate{
-				PublicKey:    pub,
-				SerialNumber: &big.Int{},
-				Issuer:       i.intermedCert.Subject,
-				NotBefore:    time.Now(),
-				NotAfter:     i.certExpiry,
-				KeyUsage:     x509.KeyUsageDigitalSignature,
-				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
-			}
-
-			cert, err := x509.CreateCertificate(
-				rand.Reader, template, i.intermedCert, template.PublicKey, i.intermedKey)
+			rresp, err := i.generateClientCertificate(rreq.PublicKey, rreq.PemCsr)
 			if err != nil {
-				http.Error(resp, fmt.Errorf("unable to create certificate: %w", err).Error(), http.StatusBadRequest)
+				http.Error(resp, err.Error(), http.StatusBadRequest)
 				return
 			}
-
-			certPEM := &bytes.Buffer{}
-			pem.Encode(certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: cert})
-
-			instancePEM := &bytes.Buffer{}
-			pem.Encode(instancePEM, &pem.Block{Type: "CERTIFICATE", Bytes: i.intermedCert.Raw})
-
-			caPEM := &bytes.Buffer{}
-			pem.Encode(caPEM, &pem.Block{Type: "CERTIFICATE", Bytes: i.rootCACert.Raw})
-
-			rresp := alloydbpb.GenerateClientCertificateResponse{
-				CaCert:              caPEM.String(),
-				PemCertificateChain: []string{certPEM.String(), instancePEM.String(), caPEM.String()},
-			}
-			if err := json.NewEncoder(resp).Encode(&rresp); err != nil {
+			if err := json.NewEncoder(resp).Encode(rresp); err != nil {
 				http.Error(resp, fmt.Errorf("unable to encode response: %w", err).Error(), http.StatusBadRequest)
 				return
 			}
@@ -186,3 +204,131 @@ func HTTPClient(requests ...*Request) (*http.Client, string, func() error) {
 	return s.Client(), s.URL, cleanup
 
 }
+
+// generateClientCertificate signs the PEM encoded public key with the
+// instance's client CA, as the `generateClientCertificate` AlloyDB Admin API
+// endpoint does. If csrPEM is set, it must be a CSR signed with and for the
+// same key.
+func (i FakeAlloyDBInstance) generateClientCertificate(pubPEM, csrPEM string) (*alloydbpb.GenerateClientCertificateResponse, error) {
+	bl, _ := pem.Decode([]byte(pubPEM))
+	if bl == nil {
+		return nil, fmt.Errorf("unable to decode CSR")
+	}
+	pub, err := x509.ParsePKCS1PublicKey(bl.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode CSR: %w", err)
+	}
+	if csrPEM != "" {
+		if err := checkCSR(csrPEM, pub); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := i.signClientCert(pub)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create certificate: %w", err)
+	}
+
+	certPEM := &bytes.Buffer{}
+	pem.Encode(certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: cert})
+
+	instancePEM := &bytes.Buffer{}
+	pem.Encode(instancePEM, &pem.Block{Type: "CERTIFICATE", Bytes: i.intermedCert.Raw})
+
+	caPEM := &bytes.Buffer{}
+	pem.Encode(caPEM, &pem.Block{Type: "CERTIFICATE", Bytes: i.rootCACert.Raw})
+
+	return &alloydbpb.GenerateClientCertificateResponse{
+		CaCert:              caPEM.String(),
+		PemCertificateChain: []string{certPEM.String(), instancePEM.String(), caPEM.String()},
+	}, nil
+}
+
+// grpcAdminServer serves the AlloyDB Admin API methods used by the dialer for
+// a single instance over gRPC.
+type grpcAdminServer struct {
+	alloydbpb.UnimplementedAlloyDBAdminServer
+	i FakeAlloyDBInstance
+}
+
+func (s *grpcAdminServer) clusterName() string {
+	return fmt.Sprintf("projects/%s/locations/%s/clusters/%s",
+		s.i.project, s.i.region, s.i.cluster)
+}
+
+func (s *grpcAdminServer) GetConnectionInfo(
+	_ context.Context, req *alloydbpb.GetConnectionInfoRequest,
+) (*alloydbpb.ConnectionInfo, error) {
+	if want := fmt.Sprintf("%s/instances/%s", s.clusterName(), s.i.name); req.Parent != want {
+		return nil, status.Errorf(codes.NotFound, "unknown instance: %v", req.Parent)
+	}
+	return &alloydbpb.ConnectionInfo{IpAddress: s.i.ipAddr, InstanceUid: s.i.uid}, nil
+}
+
+func (s *grpcAdminServer) GetCluster(
+	_ context.Context, req *alloydbpb.GetClusterRequest,
+) (*alloydbpb.Cluster, error) {
+	if req.Name != s.clusterName() {
+		return nil, status.Errorf(codes.NotFound, "unknown cluster: %v", req.Name)
+	}
+	v, ok := alloydbpb.DatabaseVersion_value[s.i.dbVersion]
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "invalid database version: %v", s.i.dbVersion)
+	}
+	return &alloydbpb.Cluster{DatabaseVersion: alloydbpb.DatabaseVersion(v)}, nil
+}
+
+func (s *grpcAdminServer) GenerateClientCertificate(
+	_ context.Context, req *alloydbpb.GenerateClientCertificateRequest,
+) (*alloydbpb.GenerateClientCertificateResponse, error) {
+	if req.Parent != s.clusterName() {
+		return nil, status.Errorf(codes.NotFound, "unknown cluster: %v", req.Parent)
+	}
+	resp, err := s.i.generateClientCertificate(req.PublicKey, req.PemCsr)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return resp, nil
+}
+
+// GRPCServer starts a TLS gRPC server that serves the AlloyDB Admin API for
+// the provided instance. It returns the server's address, a DialOption that
+// trusts the server's certificate, and a function that stops the server.
+func GRPCServer(i FakeAlloyDBInstance) (string, grpc.DialOption, func(), error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	creds := credentials.NewServerTLSFromCert(&tls.Certificate{
+		Certificate: [][]byte{i.serverCert.Raw, i.rootCACert.Raw},
+		PrivateKey:  i.serverKey,
+		Leaf:        i.serverCert,
+	})
+	s := grpc.NewServer(grpc.Creds(creds))
+	alloydbpb.RegisterAlloyDBAdminServer(s, &grpcAdminServer{i: i})
+	go s.Serve(ln)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(i.rootCACert)
+	opt := grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, ""))
+	return ln.Addr().String(), opt, s.Stop, nil
+}
+
+// checkCSR verifies that the PEM encoded CSR is signed with and for pub.
+func checkCSR(csrPEM string, pub *rsa.PublicKey) error {
+	bl, _ := pem.Decode([]byte(csrPEM))
+	if bl == nil || bl.Type != "CERTIFICATE REQUEST" {
+		return fmt.Errorf("unable to decode CSR")
+	}
+	csr, err := x509.ParseCertificateRequest(bl.Bytes)
+	if err != nil {
+		return fmt.Errorf("unable to decode CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	if csrPub, ok := csr.PublicKey.(*rsa.PublicKey); !ok || !csrPub.Equal(pub) {
+		return fmt.Errorf("CSR is not for the public key")
+	}
+	return nil
+}
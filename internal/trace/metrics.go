@@ -42,6 +42,11 @@ var (
 		"A connect or disconnect event to an AlloyDB instance",
 		stats.UnitDimensionless,
 	)
+	mDial = stats.Int64(
+		"alloydbconn/dial",
+		"A dial attempt to an AlloyDB instance",
+		stats.UnitDimensionless,
+	)
 	mDialError = stats.Int64(
 		"alloydbconn/dial_failure",
 		"A failure to dial an AlloyDB instance",
@@ -57,6 +62,11 @@ var (
 		"A failed certificate refresh operation",
 		stats.UnitDimensionless,
 	)
+	mRefreshWaitMS = stats.Int64(
+		"alloydbconn/refresh_wait",
+		"The time in milliseconds a refresh waited on the rate limiter",
+		stats.UnitMilliseconds,
+	)
 
 	latencyView = &view.View{
 		Name:        "alloydbconn/dial_latency",
@@ -73,6 +83,13 @@ var (
 		Aggregation: view.LastValue(),
 		TagKeys:     []tag.Key{keyInstance, keyDialerID},
 	}
+	dialCountView = &view.View{
+		Name:        "alloydbconn/dial_count",
+		Measure:     mDial,
+		Description: "The number of dial attempts",
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{keyInstance, keyDialerID},
+	}
 	dialFailureView = &view.View{
 		Name:        "alloydbconn/dial_failure_count",
 		Measure:     mDialError,
@@ -94,6 +111,13 @@ var (
 		Aggregation: view.Count(),
 		TagKeys:     []tag.Key{keyInstance, keyDialerID, keyErrorCode},
 	}
+	refreshWaitView = &view.View{
+		Name:        "alloydbconn/refresh_wait_latency",
+		Measure:     mRefreshWaitMS,
+		Description: "The distribution of time refreshes waited on the rate limiter (ms)",
+		Aggregation: view.Distribution(0, 5, 25, 100, 250, 500, 1000, 2000, 5000, 30000),
+		TagKeys:     []tag.Key{keyInstance, keyDialerID},
+	}
 
 	registerOnce sync.Once
 	registerErr  error
@@ -107,9 +131,11 @@ func InitMetrics() error {
 		if rErr := view.Register(
 			latencyView,
 			connectionsView,
+			dialCountView,
 			dialFailureView,
 			refreshCountView,
 			failedRefreshCountView,
+			refreshWaitView,
 		); rErr != nil {
 			registerErr = fmt.Errorf("failed to initialize metrics: %v", rErr)
 		}
@@ -117,6 +143,23 @@ func InitMetrics() error {
 	return registerErr
 }
 
+// WithLabels returns a copy of ctx tagged with the provided labels, so that
+// any metrics recorded with the returned context carry them. Labels with an
+// invalid key or value (e.g., non-printable characters) are dropped. Labels
+// cannot replace the tags set by this package.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	for k, v := range labels {
+		key, err := tag.NewKey(k)
+		if err != nil {
+			continue
+		}
+		if tagged, err := tag.New(ctx, tag.Upsert(key, v)); err == nil {
+			ctx = tagged
+		}
+	}
+	return ctx
+}
+
 // RecordDialLatency records a latency value for a call to dial.
 func RecordDialLatency(ctx context.Context, instance, dialerID string, latency int64) {
 	// tag.New creates a new context and errors only if the new tag already
@@ -133,6 +176,12 @@ func RecordOpenConnections(ctx context.Context, num int64, dialerID, instance st
 	stats.Record(ctx, mConnections.M(num))
 }
 
+// RecordDialAttempt reports a dial attempt, regardless of its result.
+func RecordDialAttempt(ctx context.Context, instance, dialerID string) {
+	ctx, _ = tag.New(ctx, tag.Upsert(keyInstance, instance), tag.Upsert(keyDialerID, dialerID))
+	stats.Record(ctx, mDial.M(1))
+}
+
 // RecordDialError reports a failed dial attempt. If err is nil, RecordDialError
 // is a no-op.
 func RecordDialError(ctx context.Context, instance, dialerID string, err error) {
@@ -157,6 +206,13 @@ func RecordRefreshResult(ctx context.Context, instance, dialerID string, err err
 	stats.Record(ctx, mSuccessfulRefresh.M(1))
 }
 
+// RecordRefreshWait records how long a refresh waited on the rate limiter
+// before it started.
+func RecordRefreshWait(ctx context.Context, instance, dialerID string, wait int64) {
+	ctx, _ = tag.New(ctx, tag.Upsert(keyInstance, instance), tag.Upsert(keyDialerID, dialerID))
+	stats.Record(ctx, mRefreshWaitMS.M(wait))
+}
+
 // errorCode returns an error code as given from the AlloyDB Admin API, provided
 // the error wraps a googleapi.Error type. If multiple error codes are returned
 // from the API, then a comma-separated string of all codes is returned.
@@ -48,6 +48,15 @@ func AddDialerID(dialerID string) Attribute {
 	return Attribute{key: "/alloydb/dialer_id", value: dialerID}
 }
 
+// AddLabels creates an attribute for each of the provided labels.
+func AddLabels(labels map[string]string) []Attribute {
+	as := make([]Attribute, 0, len(labels))
+	for k, v := range labels {
+		as = append(as, Attribute{key: k, value: v})
+	}
+	return as
+}
+
 // StartSpan begins a span with the provided name and returns a context and a
 // function to end the created span.
 func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, EndSpanFunc) {
@@ -19,9 +19,11 @@ import (
 	"testing"
 	"time"
 
-	alloydbadmin "cloud.google.com/go/alloydb/apiv1beta"
+	alloydbadmin "cloud.google.com/go/alloydb/apiv1alpha"
 	"cloud.google.com/go/alloydbconn/internal/mock"
+	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"google.golang.org/api/option"
 )
 
@@ -53,6 +55,26 @@ func (e *spyMetricsExporter) Data() []metric {
 	return res
 }
 
+// HasTag reports whether any exported row of the named view carries the
+// wanted tag.
+func (e *spyMetricsExporter) HasTag(viewName string, want tag.Tag) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range e.data {
+		if d.View.Name != viewName {
+			continue
+		}
+		for _, r := range d.Rows {
+			for _, t := range r.Tags {
+				if t == want {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // wantLastValueMetric ensures the provided metrics include a metric with the
 // wanted name and at least data point.
 func wantLastValueMetric(t *testing.T, wantName string, ms []metric) {
@@ -147,9 +169,160 @@ func TestDialerWithMetrics(t *testing.T) {
 	// success metrics
 	wantLastValueMetric(t, "alloydbconn/open_connections", spy.Data())
 	wantDistributionMetric(t, "alloydbconn/dial_latency", spy.Data())
+	wantCountMetric(t, "alloydbconn/dial_count", spy.Data())
 	wantCountMetric(t, "alloydbconn/refresh_success_count", spy.Data())
+	// Dials are tagged with the parsed instance URI, not the argument.
+	wantTag := tag.Tag{
+		Key:   tag.MustNewKey("alloydb_instance"),
+		Value: "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+	}
+	for _, v := range []string{"alloydbconn/dial_count", "alloydbconn/dial_latency", "alloydbconn/open_connections"} {
+		if !spy.HasTag(v, wantTag) {
+			t.Fatalf("want %v tagged with %v", v, wantTag)
+		}
+	}
 
 	// failure metrics from dialing bogus instance
 	wantCountMetric(t, "alloydbconn/dial_failure_count", spy.Data())
 	wantCountMetric(t, "alloydbconn/refresh_failure_count", spy.Data())
 }
+
+func TestDialerWithDialLabels(t *testing.T) {
+	// Applications aggregate by label with their own views of the
+	// connector's measures.
+	keyTenant := tag.MustNewKey("tenant_id")
+	tenantView := &view.View{
+		Name:        "test/dial_count_by_tenant",
+		Measure:     stats.Int64("alloydbconn/dial", "A dial attempt to an AlloyDB instance", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{keyTenant},
+	}
+	if err := view.Register(tenantView); err != nil {
+		t.Fatalf("failed to register view: %v", err)
+	}
+	defer view.Unregister(tenantView)
+	spy := &spyMetricsExporter{}
+	view.RegisterExporter(spy)
+	defer view.UnregisterExporter(spy)
+	view.SetReportingPeriod(time.Millisecond)
+
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 1),
+		mock.CreateEphemeralSuccess(inst, 1),
+	)
+	stop := mock.StartServerProxy(t, inst)
+	defer func() {
+		stop()
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+	c, err := alloydbadmin.NewAlloyDBAdminRESTClient(ctx, option.WithHTTPClient(mc), option.WithEndpoint(url))
+	if err != nil {
+		t.Fatalf("expected NewClient to succeed, but got error: %v", err)
+	}
+
+	d, err := NewDialer(ctx, WithTokenSource(stubTokenSource{}))
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+	d.client = c
+
+	conn, err := d.Dial(ctx,
+		"/projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance",
+		WithDialLabels(map[string]string{"tenant_id": "acme"}),
+	)
+	if err != nil {
+		t.Fatalf("expected Dial to succeed, but got error: %v", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond) // allow exporter a chance to run
+
+	if !spy.HasTag(tenantView.Name, tag.Tag{Key: keyTenant, Value: "acme"}) {
+		t.Fatalf("want metric %v with tag tenant_id = acme, got none", tenantView.Name)
+	}
+}
+
+// MaxDistribution returns the largest value recorded in the distribution of
+// the named view for rows with the wanted tag, or zero if there is none.
+func (e *spyMetricsExporter) MaxDistribution(viewName string, want tag.Tag) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var max float64
+	for _, d := range e.data {
+		if d.View.Name != viewName {
+			continue
+		}
+		for _, r := range d.Rows {
+			dd, ok := r.Data.(*view.DistributionData)
+			if !ok || dd.Max <= max {
+				continue
+			}
+			for _, t := range r.Tags {
+				if t == want {
+					max = dd.Max
+				}
+			}
+		}
+	}
+	return max
+}
+
+func TestDialerRecordsRefreshWait(t *testing.T) {
+	spy := &spyMetricsExporter{}
+	view.RegisterExporter(spy)
+	defer view.UnregisterExporter(spy)
+	view.SetReportingPeriod(time.Millisecond)
+
+	ctx := context.Background()
+	inst := mock.NewFakeInstance(
+		"my-project", "my-region", "my-cluster", "my-instance",
+	)
+	mc, url, cleanup := mock.HTTPClient(
+		mock.InstanceGetSuccess(inst, 2),
+		mock.CreateEphemeralSuccess(inst, 2),
+	)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}()
+
+	// A slow rate limiter that allows a single refresh at first.
+	d, err := NewDialer(ctx,
+		WithTokenSource(stubTokenSource{}),
+		WithHTTPClient(mc),
+		WithAdminAPIEndpoint(url),
+		WithRefreshRateLimit(200*time.Millisecond, 1),
+	)
+	if err != nil {
+		t.Fatalf("expected NewDialer to succeed, but got error: %v", err)
+	}
+	defer d.Close()
+
+	instURI := "projects/my-project/locations/my-region/clusters/my-cluster/instances/my-instance"
+	if err := d.Warmup(ctx, instURI); err != nil {
+		t.Fatalf("expected Warmup to succeed, but got error: %v", err)
+	}
+	first := d.ReportHealth()[instURI].LastRefresh
+	// The forced refresh waits on the rate limiter.
+	if err := d.ForceRefresh(instURI); err != nil {
+		t.Fatalf("expected ForceRefresh to succeed, but got error: %v", err)
+	}
+
+	dialerTag := tag.Tag{Key: tag.MustNewKey("alloydb_dialer_id"), Value: d.dialerID}
+	deadline := time.Now().Add(5 * time.Second)
+	for !d.ReportHealth()[instURI].LastRefresh.After(first) ||
+		spy.MaxDistribution("alloydbconn/refresh_wait_latency", dialerTag) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("want a non-zero refresh wait to be recorded, got none")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}